@@ -1,35 +1,57 @@
 package main
 
 import (
-	"compress/gzip"
 	"context"
-	"encoding/csv"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"html"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strconv"
-	"strings"
 	"time"
 
-	"google.golang.org/genai"
+	"purify/common/content"
+	"purify/common/fetch"
+	"purify/common/phash"
+	"purify/common/store"
 )
 
 var allowedUsers = map[string]bool{
 	"sein": true,
 }
 
+// limiter paces image downloads. main sets it from config.json.
+var limiter *fetch.Limiter
+
 func main() {
+	force := flag.Bool("force", false, "re-judge posts and images the analysis ledger already holds verdicts for")
+	flag.Parse()
+
 	wd, err := os.Getwd()
 	if err != nil {
 		panic(err)
 	}
 	parent := filepath.Dir(wd)
+	cfg, err := loadConfig(filepath.Join(parent, "config.json"))
+	if err != nil {
+		log.Fatalf("Failed to read config: %v", err)
+	}
+	limiter = fetch.NewLimiter(cfg.RateLimit)
+	ctx := context.Background()
+	cls, err := newClassifier(ctx, cfg.Classifier)
+	if err != nil {
+		log.Fatalf("Failed to create classifier: %v", err)
+	}
+	if err := run(ctx, parent, cfg, cls, *force); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run checks the top recent post of every user with cls and blocks the
+// authors it flags. Its files are read and written under parent. Unless
+// force is set, posts and images judged on earlier runs are skipped.
+func run(ctx context.Context, parent string, cfg *Config, cls Classifier, force bool) error {
 	pathBlockedUsers := filepath.Join(parent, "blocked_users.json")
 	blockedUsers, err := readBlockedUsers(pathBlockedUsers)
 	if err != nil {
@@ -37,50 +59,105 @@ func main() {
 	}
 	fmt.Printf("Loaded %d blocked users.\n", len(blockedUsers.Nicknames)+len(blockedUsers.IDs))
 
-	csvPath := filepath.Join(parent, "user_activity.csv")
-	fmt.Printf("Reading posts from CSV: %s\n", csvPath)
+	pathKnownBad := filepath.Join(parent, "flagged_images.json")
+	knownBad, err := loadKnownBad(pathKnownBad)
+	if err != nil {
+		return fmt.Errorf("failed to read flagged images: %w", err)
+	}
+	fmt.Printf("Loaded %d flagged images.\n", len(knownBad.Images))
+
+	imageHashes, err := phash.OpenIndex(filepath.Join(parent, "image_hashes.csv"))
+	if err != nil {
+		log.Printf("Failed to open image hashes: %v", err)
+	}
+	defer imageHashes.Close()
 
-	posts, err := ReadPostsFromCSV(csvPath)
+	ledger, err := openLedger(filepath.Join(parent, "analysis_ledger.jsonl"))
 	if err != nil {
-		log.Fatalf("Failed to read posts: %v", err)
+		return fmt.Errorf("failed to open analysis ledger: %w", err)
+	}
+	defer ledger.Close()
+
+	st, err := store.Open(cfg.Storage, parent)
+	if err != nil {
+		return fmt.Errorf("failed to open store: %w", err)
+	}
+	defer st.Close()
+
+	posts, err := ReadPosts(st, 3)
+	if err != nil {
+		return fmt.Errorf("failed to read posts: %w", err)
+	}
+	fmt.Printf("Posts loaded from the last 3 days: %d\n", len(posts))
+	sectionCounts := make(map[string]int)
+	replies := 0
+	for _, p := range posts {
+		sectionCounts[p.Section]++
+		if p.ParentID != 0 {
+			replies++
+		}
+	}
+	fmt.Printf("  of which tucao replies: %d\n", replies)
+	for section, n := range sectionCounts {
+		fmt.Printf("  section %s: %d posts\n", section, n)
 	}
-	fmt.Printf("Total posts loaded: %d\n", len(posts))
 
 	filtered := filterRecentPosts(posts, 3, blockedUsers)
+	if !force {
+		// Each user's top post is picked among the posts not judged yet, so
+		// their newer posts are not held back by one judged on an earlier run.
+		unjudged := ledger.unjudged(filtered)
+		fmt.Printf("Posts judged on earlier runs: %d\n", len(filtered)-len(unjudged))
+		filtered = unjudged
+	}
 	fmt.Printf("Posts to be checked in the last 3 days: %d\n", len(filtered))
 	userPosts := groupPostsByUser(filtered)
 	fmt.Printf("Total users with posts in the last 3 days: %d\n", len(userPosts))
 	topPosts := getTopPostsByVoteNegative(userPosts)
 
+	pa := &postAnalyzer{
+		cls:          cls,
+		knownBad:     knownBad,
+		pathKnownBad: pathKnownBad,
+		maxDistance:  cfg.KnownBadMaxDistance,
+		imageHashes:  imageHashes,
+		thread:       cfg.Thread,
+		threads:      newThreadIndex(posts),
+		ledger:       ledger,
+		force:        force,
+	}
 	for _, utp := range topPosts {
-		url, mimeType := ExtractImgSrcs(utp.Post.Content)
-		if len(url) < 1 {
-			continue
-		}
-		imageBytes, err := downloadImage(url)
+		pv, ok, err := pa.analyze(ctx, utp.Post)
 		if err != nil {
-			log.Printf("%v", err)
+			log.Printf("Failed to analyze post content: %v", err)
+			break
+		}
+		if !ok {
 			continue
 		}
-
-		shouldBlock, err := analyzeContentWithGenAI(imageBytes, mimeType)
-		if err != nil {
-			log.Printf("Failed to analyze image content: %v", err)
-			break
+		for _, iv := range pv.Images {
+			fmt.Printf("  %s: %s %s (%.2f): %s\n", iv.URL, iv.Verdict.Decision, iv.Verdict.Category, iv.Verdict.Confidence, iv.Verdict.Rationale)
 		}
-		if shouldBlock {
-			if utp.Post.UserId != 0 {
-				blockedUsers.IDs = append(blockedUsers.IDs, utp.Post.UserId)
-				blockedUsers.Mappings[strconv.Itoa(utp.Post.UserId)] = utp.Post.Author
-			} else {
-				blockedUsers.Nicknames = append(blockedUsers.Nicknames, utp.Post.Author)
+		switch {
+		case pv.Verdict.Blocks():
+			fmt.Printf("UserId: %d, Author: %s, Post ID: %d (%s): %s.\n", utp.Post.UserId, utp.Post.Author, utp.Post.ID, utp.Post.Section, pv.Reason)
+			history := outcomesOf(ledger.ofUser(utp.Post.UserId, utp.Post.Author))
+			rule, detail, met := cfg.Policy.evaluate(history, time.Now().UTC())
+			if !met {
+				fmt.Printf("  no blocking rule met yet\n")
+				break
 			}
+			blockUser(&blockedUsers, utp.Post, rule.Name, fmt.Sprintf("%s; last: %s", detail, pv.Reason))
 			persistBlockedUser(pathBlockedUsers, blockedUsers)
-			fmt.Printf("UserId: %d, Author: %s, Post ID: %d, Image URL: %s is flagged by GenAI analysis.\n", utp.Post.UserId, utp.Post.Author, utp.Post.ID, url)
-		} else {
+			fmt.Printf("  blocked by rule %s: %s\n", rule.Name, detail)
+		case pv.Verdict.Decision == DecisionUndecided:
+			fmt.Printf("Post ID: %d is undecided: %s\n", utp.Post.ID, pv.Verdict.Rationale)
+		default:
 			fmt.Printf("Post ID: %d is clean.\n", utp.Post.ID)
 		}
 	}
+	pa.stats.report()
+	return nil
 }
 
 // readBlockedUsers reads a list of blocked users from a file (one username per line).
@@ -204,147 +281,32 @@ func getTopPostsByVoteNegative(userPosts map[string][]Post) []UserTopPost {
 	return topPosts
 }
 
-func analyzeContentWithGenAI(content []byte, mimeType string) (bool, error) {
-	ctx := context.Background()
-	// The client gets the API key from the environment variable `GEMINI_API_KEY`.
-	client, err := genai.NewClient(ctx, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	parts := []*genai.Part{
-		genai.NewPartFromBytes(content, mimeType),
-		genai.NewPartFromText(`Return YES or NO, Does the image satisfy at least one of the following conditions?
-		
-			1. Social Media Content or Interactions:
-			
-				- Screenshots of chat conversations, including:
-					- Individuals complaining, venting, or expressing dissatisfaction to others about relationships, work, family, news, or personal matters.
-					- People seeking advice, empathy, validation, or support from friends, acquaintances, or the public.
-					- Sharing personal experiences, achievements, struggles, or life events in messages or posts.
-					- Discussions or debates around current events, news, societal issues, policies, or trending topics.
-					- Exchanges involving arguments, heated discussions, or confrontations in group chats, community threads, or comment sections.
-					- Posts, tweets, or comments reflecting strong, controversial, or inflammatory opinions (excluding those that are simply humorous, lighthearted, or interesting).
-					- Content discussing or responding to memes, viral trends, online challenges, or pop culture phenomena only if the discussion or reaction is likely to provoke dispute, offense, or controversy; ordinary, funny, or non-controversial memes are excluded.
-					- Conversations about dating, relationships, boundaries, or social expectations.
-					
-			2. Gender-Related Themes:
-			
-				- Depictions, implications, or discussions of gender conflict (such as disagreements or disputes about perspectives, roles, or privileges across genders).
-				- Content suggesting entitlement—especially by females—to financial, emotional, or social benefits (including topics like "gold-digging," relationship demands, or debates over gender privilege).
-				- Discourse around traditional vs. modern gender roles or stereotypes.
-				
-			3. Unsettling or Disturbing Content:
-			
-				- Images featuring animals or creatures commonly associated with fear or discomfort (e.g., snakes, spiders, insects, or other phobia-inducing wildlife).
-				- Scenes depicting injuries, medical conditions, graphic, or otherwise distressing content.
-				- Unnerving, bizarre, or grotesque visuals designed to provoke a sense of unease.
-				
-			4. Dispute-Provoking or Controversial Content:
-			
-				- Content featuring or related to heated debates, arguments, or controversy about political, social, religious, or ideological subjects.
-				- Posts, images, or screenshots likely to spark strong emotional reactions (including offensive memes, inflammatory statements, or polarizing opinions).
-				- Material promoting misinformation, conspiracy theories, or unfounded claims.
-				- Content explicitly designed to provoke, incite arguments, or “troll” others.
-		`),
-	}
-
-	contents := []*genai.Content{
-		genai.NewContentFromParts(parts, genai.RoleUser),
-	}
-
-	result, err := client.Models.GenerateContent(
-		ctx,
-		"gemini-2.5-flash",
-		contents,
-		nil,
-	)
-	if err != nil {
-		return false, err
-	}
-
-	if strings.Contains(strings.ToLower(result.Text()), "yes") {
-		fmt.Printf("%s\r\n", result.Text())
-		return true, nil
-	} else {
-		return false, nil
-	}
-}
-
-func downloadImage(url string) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request for %s: %v", url, err)
-	}
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7")
-	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36")
-	client := &http.Client{}
-	imageResp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to download image from %s: %v", url, err)
-	}
-	defer imageResp.Body.Close()
-
-	var reader io.Reader = imageResp.Body
-	switch imageResp.Header.Get("Content-Encoding") {
-	case "gzip":
-		gzReader, err := gzip.NewReader(imageResp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create gzip reader for %s: %v", url, err)
-		}
-		defer gzReader.Close()
-		reader = gzReader
-		// Add more encodings if needed (e.g., deflate, br)
-	}
-
-	imageBytes, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read image body from %s: %v", url, err)
-	}
-	return imageBytes, nil
-}
-
-// ReadPostsFromCSV reads posts from a CSV file and returns a slice of Post structs.
-func ReadPostsFromCSV(path string) ([]Post, error) {
-	file, err := os.Open(path)
+// ReadPosts returns the stored posts of the last days as Post structs.
+func ReadPosts(st store.Store, days int) ([]Post, error) {
+	records, err := st.QueryByTimeRange(time.Now().UTC().AddDate(0, 0, -days), time.Time{})
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	reader.FieldsPerRecord = 7
-
-	records, err := reader.ReadAll()
-	if err != nil {
-		return nil, err
-	}
-
-	var posts []Post
-	for i, rec := range records {
-		if i == 0 {
-			// skip header
-			continue
+	posts := make([]Post, 0, len(records))
+	for _, rec := range records {
+		parsed := rec.Parsed
+		if parsed.IsZero() {
+			// Stored before the crawler parsed comments.
+			parsed = content.Parse(rec.Content)
 		}
-		if len(rec) < 6 {
-			continue // skip incomplete rows
-		}
-		id, _ := strconv.Atoi(rec[0])
-		userId, _ := strconv.Atoi(rec[2])
-		voteNeg, _ := strconv.Atoi(rec[4])
-		votePos, _ := strconv.Atoi(rec[5])
-		content := html.UnescapeString(rec[6])
-		post := Post{
-			ID:           id,
-			Author:       rec[1],
-			UserId:       userId,
-			DateGMT:      rec[3],
-			Content:      content,
-			VoteNegative: voteNeg,
-			VotePositive: votePos,
-		}
-		posts = append(posts, post)
+		posts = append(posts, Post{
+			ID:           rec.ID,
+			Author:       rec.Author,
+			UserId:       rec.UserID,
+			DateGMT:      rec.DateGMT,
+			Content:      rec.Content,
+			VoteNegative: rec.VoteNegative,
+			VotePositive: rec.VotePositive,
+			Section:      rec.Section,
+			ParentID:     rec.ParentID,
+			Images:       parsed.Images,
+			Text:         parsed.Text,
+		})
 	}
 	return posts, nil
 }
@@ -357,29 +319,12 @@ type Post struct {
 	Content      string
 	VoteNegative int
 	VotePositive int
-}
-
-// ExtractImgSrcs extracts all src values from img tags in the given HTML content.
-const imgSrcRegex = `<img\s+[^>]*src=["']([^"']+)["']`
-
-var imgSrcRe = regexp.MustCompile(imgSrcRegex)
-
-func ExtractImgSrcs(content string) (string, string) {
-	matches := imgSrcRe.FindAllStringSubmatch(content, -1)
-	for _, m := range matches {
-		mimeType := ""
-		isJpg := strings.HasSuffix(m[1], "jpg") || strings.HasSuffix(m[1], "jpeg")
-		isPng := strings.HasSuffix(m[1], "png")
-		if len(m) > 1 && (isJpg || isPng) {
-			if isJpg {
-				mimeType = "image/jpeg"
-			} else if isPng {
-				mimeType = "image/png"
-			}
-			return m[1], mimeType
-		}
-	}
-	return "", ""
+	Section      string
+	// ParentID is the comment a tucao reply was posted under, 0 for top-level comments.
+	ParentID int
+	Images   []content.Image
+	// Text is the plain text of Content.
+	Text string
 }
 
 // BlockedUsers represents the structure of blocked_users.json
@@ -387,6 +332,34 @@ type BlockedUsers struct {
 	IDs       []int             `json:"ids"`
 	Nicknames []string          `json:"nicknames"`
 	Mappings  map[string]string `json:"mappings"`
+	// Reasons explains each block, keyed like Mappings by user ID, or by
+	// nickname for users without one.
+	Reasons map[string]string `json:"reasons,omitempty"`
+	// Rules names the policy rule behind each block, keyed like Reasons.
+	Rules map[string]string `json:"rules,omitempty"`
+}
+
+// blockUser adds the author of post to blocked, recording the rule that fired and why.
+func blockUser(blocked *BlockedUsers, post Post, rule, reason string) {
+	if blocked.Mappings == nil {
+		blocked.Mappings = make(map[string]string)
+	}
+	if blocked.Reasons == nil {
+		blocked.Reasons = make(map[string]string)
+	}
+	if blocked.Rules == nil {
+		blocked.Rules = make(map[string]string)
+	}
+	key := post.Author
+	if post.UserId != 0 {
+		key = strconv.Itoa(post.UserId)
+		blocked.IDs = append(blocked.IDs, post.UserId)
+		blocked.Mappings[key] = post.Author
+	} else {
+		blocked.Nicknames = append(blocked.Nicknames, post.Author)
+	}
+	blocked.Reasons[key] = reason
+	blocked.Rules[key] = rule
 }
 
 // persistBlockedUser saves the blocked users to a JSON file at the given path.
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"purify/common/content"
+	"purify/common/fetch"
+	"purify/common/store"
+)
+
+// testImages serves PNGs whose perceptual hashes are far apart: "/dark.png"
+// darkens, "/flat.png" stays level and "/valley.png" darkens then brightens
+// from left to right.
+func testImages(t *testing.T) *httptest.Server {
+	t.Helper()
+	encode := func(fill func(x int) uint8) []byte {
+		img := image.NewGray(image.Rect(0, 0, 16, 16))
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				img.SetGray(x, y, color.Gray{Y: fill(x)})
+			}
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	images := map[string][]byte{
+		"/dark.png": encode(func(x int) uint8 { return uint8(255 - 16*x) }),
+		"/flat.png": encode(func(int) uint8 { return 128 }),
+		"/valley.png": encode(func(x int) uint8 {
+			if x < 8 {
+				return uint8(255 - 32*x)
+			}
+			return uint8(32 * (x - 8))
+		}),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := images[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testRepo stores recs in a CSV store under a temporary repository root and
+// returns the root with a config using the fake classifier.
+func testRepo(t *testing.T, rules []FakeRule, recs ...store.Record) (string, *Config) {
+	t.Helper()
+	limiter = fetch.NewLimiter(fetch.LimiterConfig{MinRate: 100, MaxRate: 1000})
+	parent := t.TempDir()
+	cfg := defaultConfig
+	cfg.Storage = store.Config{Backend: "csv"}
+	cfg.Classifier = ClassifierConfig{Backend: "fake", Rules: rules}
+	st, err := store.Open(cfg.Storage, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	for _, rec := range recs {
+		if _, err := st.InsertIfAbsent(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return parent, &cfg
+}
+
+// testPost is a recent post of a user showing the image at url, if any.
+func testPost(id, userID int, author, text, url string) store.Record {
+	rec := store.Record{
+		ID:      id,
+		UserID:  userID,
+		Author:  author,
+		Section: "pic",
+		DateGMT: time.Now().UTC().Format(time.RFC3339),
+		Content: text,
+		Parsed:  content.Parsed{Text: text},
+	}
+	if url != "" {
+		rec.Parsed.Images = []content.Image{{Original: url, Large: url}}
+	}
+	return rec
+}
+
+// TestRunOffline runs the whole analysis against a temporary repository with
+// the fake classifier and images served locally.
+func TestRunOffline(t *testing.T) {
+	srv := testImages(t)
+	parent, cfg := testRepo(t, []FakeRule{{TextContains: "drama", Block: true}, {Author: "fred", Block: true}},
+		testPost(10, 1, "alice", "so much drama", srv.URL+"/dark.png"),
+		testPost(20, 2, "bob", "a cat", srv.URL+"/flat.png"),
+		testPost(30, 3, "carol", "nice weather", ""),
+		// erin shows alice's image without her text, fred an image bad on its own.
+		testPost(50, 5, "erin", "", srv.URL+"/dark.png"),
+		testPost(60, 6, "fred", "look", srv.URL+"/valley.png"),
+	)
+
+	ctx := context.Background()
+	cls, err := newClassifier(ctx, cfg.Classifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run(ctx, parent, cfg, cls, false); err != nil {
+		t.Fatal(err)
+	}
+
+	blocked, err := readBlockedUsers(filepath.Join(parent, "blocked_users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocked.IDs) != 2 || blocked.Mappings["1"] == "" || blocked.Mappings["6"] == "" {
+		t.Fatalf("blocked IDs = %v, want alice and fred", blocked.IDs)
+	}
+	if blocked.Mappings["1"] != "alice" || !strings.Contains(blocked.Reasons["1"], "flagged by fake") {
+		t.Errorf("block of alice recorded as mapping %q, reason %q", blocked.Mappings["1"], blocked.Reasons["1"])
+	}
+	if blocked.Rules["1"] != "confident" {
+		t.Errorf("alice blocked by rule %q, want confident", blocked.Rules["1"])
+	}
+
+	knownBad, err := loadKnownBad(filepath.Join(parent, "flagged_images.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// alice's image was only flagged for her text.
+	if len(knownBad.Images) != 1 || knownBad.Images[0].PostID != 60 {
+		t.Errorf("flagged images = %+v, want the image of post 60", knownBad.Images)
+	}
+
+	ledgerPath := filepath.Join(parent, "analysis_ledger.jsonl")
+	entries := readLedgerEntries(t, ledgerPath)
+	want := map[int]Decision{10: DecisionBlock, 20: DecisionAllow, 30: DecisionAllow, 50: DecisionAllow, 60: DecisionBlock}
+	if len(entries) != len(want) {
+		t.Fatalf("ledger has %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for _, e := range entries {
+		if e.Decision != want[e.PostID] {
+			t.Errorf("post %d judged %s, want %s", e.PostID, e.Decision, want[e.PostID])
+		}
+		if e.ImageAlone != (e.PostID == 60) {
+			t.Errorf("post %d judged on the image alone: %v", e.PostID, e.ImageAlone)
+		}
+		if e.Classifier != "fake" || e.PromptVersion != promptVersion {
+			t.Errorf("post %d judged by %q with prompt %d", e.PostID, e.Classifier, e.PromptVersion)
+		}
+		if e.PostID == 20 && (e.URL != srv.URL+"/flat.png" || e.Hash == "") {
+			t.Errorf("image verdict of post 20 has URL %q, hash %q", e.URL, e.Hash)
+		}
+	}
+
+	// A second run finds everything judged and asks the classifier nothing.
+	if err := run(ctx, parent, cfg, cls, false); err != nil {
+		t.Fatal(err)
+	}
+	if again := readLedgerEntries(t, ledgerPath); len(again) != len(entries) {
+		t.Errorf("second run added %d ledger entries", len(again)-len(entries))
+	}
+}
+
+// TestRunResumesPost judges the images of a post left unjudged by an earlier
+// run without judging the others again.
+func TestRunResumesPost(t *testing.T) {
+	srv := testImages(t)
+	post := testPost(40, 4, "dave", "two pictures", srv.URL+"/dark.png")
+	post.Parsed.Images = append(post.Parsed.Images, content.Image{Original: srv.URL + "/flat.png", Large: srv.URL + "/flat.png"})
+	parent, cfg := testRepo(t, nil, post)
+
+	ledgerPath := filepath.Join(parent, "analysis_ledger.jsonl")
+	earlier := LedgerEntry{Section: "pic", PostID: 40, UserID: 4, Author: "dave", URL: srv.URL + "/dark.png", Decision: DecisionAllow, Classifier: "fake"}
+	b, err := json.Marshal(earlier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ledgerPath, append(b, '\n'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cls, err := newClassifier(ctx, cfg.Classifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run(ctx, parent, cfg, cls, false); err != nil {
+		t.Fatal(err)
+	}
+	entries := readLedgerEntries(t, ledgerPath)
+	if len(entries) != 2 || entries[1].PostID != 40 || entries[1].URL != srv.URL+"/flat.png" {
+		t.Fatalf("ledger = %+v, want the earlier entry and one for the second image", entries)
+	}
+
+	if err := run(ctx, parent, cfg, cls, false); err != nil {
+		t.Fatal(err)
+	}
+	if again := readLedgerEntries(t, ledgerPath); len(again) != len(entries) {
+		t.Errorf("run on a judged post added %d ledger entries", len(again)-len(entries))
+	}
+}
+
+func readLedgerEntries(t *testing.T, path string) []LedgerEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []LedgerEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e LedgerEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// Input is what a Classifier judges: the plain text of a post, one of its
+// images, or both. Image is nil for text-only input.
+type Input struct {
+	Image    []byte
+	MIMEType string
+	Text     string
+	// Context is the post's thread, nil when none is sent.
+	Context *PostContext
+	Post    Post
+}
+
+// Verdict is a Classifier's judgement of an Input.
+type Verdict struct {
+	Decision Decision
+	// Category is one of the Category constants.
+	Category   string
+	Confidence float64
+	Rationale  string
+	// Raw is the classifier's reply as received, kept for undecided verdicts.
+	Raw string
+}
+
+// Blocks reports whether the verdict is to block the author.
+func (v Verdict) Blocks() bool {
+	return v.Decision == DecisionBlock
+}
+
+// Classifier decides whether a post's image or text warrants blocking its author.
+type Classifier interface {
+	Classify(ctx context.Context, in Input) (Verdict, error)
+	// Name identifies the classifier, and its model where it has one, in block reasons.
+	Name() string
+}
+
+// ClassifierConfig selects a classifier in config.json.
+type ClassifierConfig struct {
+	// Backend is "gemini", "openai" or "fake".
+	Backend string `json:"backend"`
+	// Model is the model the backend asks.
+	Model string `json:"model"`
+	// BaseURL and APIKey locate the openai backend's server, for example
+	// http://localhost:11434 for Ollama.
+	BaseURL string `json:"base_url"`
+	APIKey  string `json:"api_key"`
+	// Rules and Default drive the fake backend.
+	Rules   []FakeRule `json:"rules"`
+	Default bool       `json:"default"`
+}
+
+const defaultGeminiModel = "gemini-2.5-flash"
+
+// newClassifier builds the configured classifier.
+func newClassifier(ctx context.Context, cfg ClassifierConfig) (Classifier, error) {
+	switch cfg.Backend {
+	case "", "gemini":
+		if cfg.Model == "" {
+			cfg.Model = defaultGeminiModel
+		}
+		return newGeminiClassifier(ctx, cfg.Model)
+	case "openai":
+		return newOpenAIClassifier(cfg.BaseURL, cfg.Model, cfg.APIKey)
+	case "fake":
+		return &FakeClassifier{Rules: cfg.Rules, Default: cfg.Default}, nil
+	default:
+		return nil, fmt.Errorf("unknown classifier backend %q", cfg.Backend)
+	}
+}
+
+// moderationPrompt asks whether the image, the text or both of in fall into a
+// category worth blocking.
+func moderationPrompt(in Input) string {
+	var b strings.Builder
+	switch {
+	case in.Image != nil && in.Text != "":
+		b.WriteString("Does the image, together with the comment text below, satisfy at least one of the following conditions?\n")
+	case in.Image != nil:
+		b.WriteString("Does the image satisfy at least one of the following conditions?\n")
+	default:
+		b.WriteString("Does the comment text below satisfy at least one of the following conditions?\n")
+	}
+	b.WriteString(moderationConditions)
+	if in.Text != "" {
+		b.WriteString("\nComment text:\n\"\"\"\n")
+		b.WriteString(in.Text)
+		b.WriteString("\n\"\"\"\n")
+	}
+	if in.Context != nil {
+		b.WriteString(in.Context.prompt())
+	}
+	b.WriteString(verdictInstructions)
+	return b.String()
+}
+
+// moderationConditions are the categories worth blocking, shared by image and
+// text prompts.
+const moderationConditions = `		
+			1. Social Media Content or Interactions:
+			
+				- Screenshots of chat conversations, including:
+					- Individuals complaining, venting, or expressing dissatisfaction to others about relationships, work, family, news, or personal matters.
+					- People seeking advice, empathy, validation, or support from friends, acquaintances, or the public.
+					- Sharing personal experiences, achievements, struggles, or life events in messages or posts.
+					- Discussions or debates around current events, news, societal issues, policies, or trending topics.
+					- Exchanges involving arguments, heated discussions, or confrontations in group chats, community threads, or comment sections.
+					- Posts, tweets, or comments reflecting strong, controversial, or inflammatory opinions (excluding those that are simply humorous, lighthearted, or interesting).
+					- Content discussing or responding to memes, viral trends, online challenges, or pop culture phenomena only if the discussion or reaction is likely to provoke dispute, offense, or controversy; ordinary, funny, or non-controversial memes are excluded.
+					- Conversations about dating, relationships, boundaries, or social expectations.
+					
+			2. Gender-Related Themes:
+			
+				- Depictions, implications, or discussions of gender conflict (such as disagreements or disputes about perspectives, roles, or privileges across genders).
+				- Content suggesting entitlement—especially by females—to financial, emotional, or social benefits (including topics like "gold-digging," relationship demands, or debates over gender privilege).
+				- Discourse around traditional vs. modern gender roles or stereotypes.
+				
+			3. Unsettling or Disturbing Content:
+			
+				- Images featuring animals or creatures commonly associated with fear or discomfort (e.g., snakes, spiders, insects, or other phobia-inducing wildlife).
+				- Scenes depicting injuries, medical conditions, graphic, or otherwise distressing content.
+				- Unnerving, bizarre, or grotesque visuals designed to provoke a sense of unease.
+				
+			4. Dispute-Provoking or Controversial Content:
+			
+				- Content featuring or related to heated debates, arguments, or controversy about political, social, religious, or ideological subjects.
+				- Posts, images, or screenshots likely to spark strong emotional reactions (including offensive memes, inflammatory statements, or polarizing opinions).
+				- Material promoting misinformation, conspiracy theories, or unfounded claims.
+				- Content explicitly designed to provoke, incite arguments, or “troll” others.
+		`
+
+// GeminiClassifier asks a Gemini model with moderationPrompt.
+type GeminiClassifier struct {
+	client *genai.Client
+	model  string
+}
+
+// newGeminiClassifier creates the client once for every later call. The
+// client gets the API key from the environment variable `GEMINI_API_KEY`.
+func newGeminiClassifier(ctx context.Context, model string) (*GeminiClassifier, error) {
+	client, err := genai.NewClient(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &GeminiClassifier{client: client, model: model}, nil
+}
+
+func (g *GeminiClassifier) Name() string {
+	return "gemini/" + g.model
+}
+
+func (g *GeminiClassifier) Classify(ctx context.Context, in Input) (Verdict, error) {
+	var parts []*genai.Part
+	if in.Image != nil {
+		parts = append(parts, genai.NewPartFromBytes(in.Image, in.MIMEType))
+	}
+	parts = append(parts, genai.NewPartFromText(moderationPrompt(in)))
+	contents := []*genai.Content{
+		genai.NewContentFromParts(parts, genai.RoleUser),
+	}
+	config := &genai.GenerateContentConfig{
+		ResponseMIMEType:   "application/json",
+		ResponseJsonSchema: verdictSchema,
+	}
+	result, err := g.client.Models.GenerateContent(ctx, g.model, contents, config)
+	if err != nil {
+		return Verdict{}, err
+	}
+	return parseVerdict(result.Text()), nil
+}
+
+// FakeRule matches posts for FakeClassifier. Empty fields match anything;
+// a rule with every field empty matches every post.
+type FakeRule struct {
+	Author       string `json:"author"`
+	UserID       int    `json:"user_id"`
+	PostID       int    `json:"post_id"`
+	Section      string `json:"section"`
+	TextContains string `json:"text_contains"`
+	// MinReplies matches inputs whose thread context holds at least this many replies.
+	MinReplies int  `json:"min_replies"`
+	Block      bool `json:"block"`
+	// Category is reported for blocking verdicts; it defaults to controversial.
+	Category string `json:"category"`
+}
+
+func (r FakeRule) matches(in Input) bool {
+	p := in.Post
+	return (r.Author == "" || r.Author == p.Author) &&
+		(r.UserID == 0 || r.UserID == p.UserId) &&
+		(r.PostID == 0 || r.PostID == p.ID) &&
+		(r.Section == "" || r.Section == p.Section) &&
+		(r.TextContains == "" || strings.Contains(in.Text, r.TextContains)) &&
+		(r.MinReplies == 0 || in.Context != nil && len(in.Context.Replies) >= r.MinReplies)
+}
+
+// FakeClassifier judges posts by the first rule they match, and by Default
+// when none does, so the analyzer can run offline and reproducibly.
+type FakeClassifier struct {
+	Rules   []FakeRule
+	Default bool
+}
+
+func (f *FakeClassifier) Name() string {
+	return "fake"
+}
+
+func (f *FakeClassifier) Classify(_ context.Context, in Input) (Verdict, error) {
+	for i, r := range f.Rules {
+		if r.matches(in) {
+			return fakeVerdict(r.Block, r.Category, fmt.Sprintf("rule %d", i+1)), nil
+		}
+	}
+	return fakeVerdict(f.Default, "", "default"), nil
+}
+
+func fakeVerdict(block bool, category, rationale string) Verdict {
+	if !block {
+		return Verdict{Decision: DecisionAllow, Category: CategoryNone, Confidence: 1, Rationale: rationale}
+	}
+	if category == "" {
+		category = CategoryControversial
+	}
+	return Verdict{Decision: DecisionBlock, Category: category, Confidence: 1, Rationale: rationale}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"purify/common/fetch"
+	"purify/common/store"
+)
+
+// Config represents the parts of config.json the analyzer uses.
+type Config struct {
+	// RateLimit bounds the adaptive rate of image downloads.
+	RateLimit fetch.LimiterConfig `json:"rate_limit"`
+	// Storage selects where the crawled comments are read from.
+	Storage store.Config `json:"storage"`
+	// KnownBadMaxDistance is the largest Hamming distance at which an image
+	// counts as a copy of a flagged one; negative disables the check.
+	KnownBadMaxDistance int `json:"known_bad_max_distance"`
+	// Classifier selects what judges the images.
+	Classifier ClassifierConfig `json:"classifier"`
+	// Thread sets the reply-thread context sent with each post.
+	Thread ThreadConfig `json:"thread"`
+	// Policy decides when flagged posts get their author blocked.
+	Policy PolicyConfig `json:"policy"`
+}
+
+var defaultConfig = Config{
+	KnownBadMaxDistance: 6,
+	Thread:              ThreadConfig{MaxReplies: 5},
+	Policy:              defaultPolicy,
+}
+
+// loadConfig reads config.json, falling back to the defaults when the file is missing.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Policy.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"net/http"
+)
+
+// maxGIFFrames is how many frames of an animated GIF are classified.
+const maxGIFFrames = 4
+
+// classifiableTypes are the image types that can be classified. GIFs are
+// sent as PNG frames, since not every classifier accepts them.
+var classifiableTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// frame is one image to classify with its MIME type.
+type frame struct {
+	data     []byte
+	mimeType string
+}
+
+// sniffMIME returns the MIME type of image data judged from its bytes, or ""
+// when it is not an image that can be classified.
+func sniffMIME(data []byte) string {
+	t := http.DetectContentType(data)
+	if !classifiableTypes[t] {
+		return ""
+	}
+	return t
+}
+
+// framesOf returns what to classify for an image: the image itself, or for a
+// GIF up to maxGIFFrames frames spread over the animation as PNGs. A GIF that
+// does not decode yields no frames.
+func framesOf(data []byte, mimeType string) []frame {
+	if mimeType != "image/gif" {
+		return []frame{{data: data, mimeType: mimeType}}
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil || len(g.Image) == 0 {
+		return nil
+	}
+	sampled := make(map[int]bool)
+	for k := 0; k < maxGIFFrames; k++ {
+		sampled[k*(len(g.Image)-1)/(maxGIFFrames-1)] = true
+	}
+
+	// Frames after the first only hold what changed, so each sampled frame is
+	// taken from a canvas the animation is played onto.
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	var frames []frame
+	for i, img := range g.Image {
+		var previous *image.RGBA
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			draw.Draw(previous, previous.Bounds(), canvas, image.Point{}, draw.Src)
+		}
+		draw.Draw(canvas, img.Bounds(), img, img.Bounds().Min, draw.Over)
+		if sampled[i] {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, canvas); err == nil {
+				frames = append(frames, frame{data: buf.Bytes(), mimeType: "image/png"})
+			}
+		}
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, img.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
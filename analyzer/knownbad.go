@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"purify/common/phash"
+)
+
+// FlaggedImage is an image the classifier judged objectionable.
+type FlaggedImage struct {
+	Hash     phash.Hash `json:"hash"`
+	Section  string     `json:"section"`
+	PostID   int        `json:"post_id"`
+	URL      string     `json:"url"`
+	Category string     `json:"category,omitempty"`
+	// Confidence is the classifier's confidence in the flag, 0 for images
+	// flagged before it was kept.
+	Confidence float64   `json:"confidence,omitempty"`
+	FlaggedAt  time.Time `json:"flagged_at"`
+}
+
+// KnownBad is the list of flagged images kept in flagged_images.json. Posters
+// of near-identical images are blocked without asking the classifier again.
+type KnownBad struct {
+	Images []FlaggedImage `json:"images"`
+}
+
+// loadKnownBad reads the list at path, returning an empty one when the file is missing.
+func loadKnownBad(path string) (*KnownBad, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &KnownBad{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var k KnownBad
+	if err := json.Unmarshal(b, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// match returns the flagged image closest to h if it is at most maxDistance
+// bits away. A negative maxDistance disables matching.
+func (k *KnownBad) match(h phash.Hash, maxDistance int) (FlaggedImage, int, bool) {
+	best, bestDist := FlaggedImage{}, maxDistance+1
+	for _, img := range k.Images {
+		if d := phash.Distance(h, img.Hash); d < bestDist {
+			best, bestDist = img, d
+		}
+	}
+	return best, bestDist, bestDist <= maxDistance
+}
+
+// add records a newly flagged image and saves the list to path.
+func (k *KnownBad) add(path string, img FlaggedImage) error {
+	k.Images = append(k.Images, img)
+	b, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// promptVersion identifies the wording of moderationPrompt and verdictSchema
+// in the ledger. Bump it whenever either changes.
+const promptVersion = 1
+
+// LedgerEntry is one recorded verdict. Hash and URL are empty for verdicts on
+// a post's text. ImageAlone marks image verdicts that hold for the image on
+// its own: reached without the post's text or thread, or a block confirmed
+// on the image alone. Only those are reused for the same image elsewhere.
+// PostedAt is zero when the post's date did not parse.
+type LedgerEntry struct {
+	Section       string    `json:"section"`
+	PostID        int       `json:"post_id"`
+	UserID        int       `json:"user_id"`
+	Author        string    `json:"author"`
+	Hash          string    `json:"hash,omitempty"`
+	URL           string    `json:"url,omitempty"`
+	ImageAlone    bool      `json:"image_alone,omitempty"`
+	Decision      Decision  `json:"decision"`
+	Category      string    `json:"category"`
+	Confidence    float64   `json:"confidence"`
+	Rationale     string    `json:"rationale"`
+	Classifier    string    `json:"classifier"`
+	PromptVersion int       `json:"prompt_version"`
+	PostedAt      time.Time `json:"posted_at"`
+	JudgedAt      time.Time `json:"judged_at"`
+}
+
+// Ledger is the append-only record of every verdict in analysis_ledger.jsonl,
+// so a post or image judged on one run is not sent to a classifier again.
+type Ledger struct {
+	mu      sync.Mutex
+	f       *os.File
+	judged  map[ledgerKey]bool
+	byHash  map[string]LedgerEntry
+	entries []LedgerEntry
+}
+
+// ledgerKey identifies a judged post text (empty ref) or image URL.
+type ledgerKey struct {
+	section string
+	postID  int
+	ref     string
+}
+
+// openLedger opens or creates the ledger at path.
+func openLedger(path string) (*Ledger, error) {
+	l := &Ledger{
+		judged: make(map[ledgerKey]bool),
+		byHash: make(map[string]LedgerEntry),
+	}
+	if err := l.load(path); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l.f = f
+	return l, nil
+}
+
+func (l *Ledger) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e LedgerEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		l.mark(e)
+	}
+	return sc.Err()
+}
+
+// judgedRef reports whether the post's text (ref "") or its image at URL ref
+// has a recorded verdict.
+func (l *Ledger) judgedRef(section string, postID int, ref string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.judged[ledgerKey{section, postID, ref}]
+}
+
+// unjudged returns the posts with something left to judge.
+func (l *Ledger) unjudged(posts []Post) []Post {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Post, 0, len(posts))
+	for _, p := range posts {
+		if !l.done(p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// done reports whether every image of p has a recorded verdict, or its text
+// does. A post's text is judged alone only once none of its images could be,
+// so a verdict on it settles the post. l.mu must be held.
+func (l *Ledger) done(p Post) bool {
+	if l.judged[ledgerKey{p.Section, p.ID, ""}] || len(p.Images) == 0 && p.Text == "" {
+		return true
+	}
+	if len(p.Images) == 0 {
+		return false
+	}
+	for _, img := range p.Images {
+		if !l.judged[ledgerKey{p.Section, p.ID, img.Large}] {
+			return false
+		}
+	}
+	return true
+}
+
+// byImageHash returns the latest verdict on an image with the given hash
+// alone, whichever post showed it.
+func (l *Ledger) byImageHash(hash string) (LedgerEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.byHash[hash]
+	return e, ok
+}
+
+// record appends a verdict.
+func (l *Ledger) record(e LedgerEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	l.mark(e)
+	return nil
+}
+
+// ofUser returns the entries about posts of a user, matched by ID when the
+// user has one and by author name otherwise.
+func (l *Ledger) ofUser(userID int, author string) []LedgerEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []LedgerEntry
+	for _, e := range l.entries {
+		if userID != 0 && e.UserID == userID || userID == 0 && e.UserID == 0 && e.Author == author {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// mark keeps e and notes what it judged. Undecided verdicts are kept but not
+// noted as judged, so the next run tries again.
+func (l *Ledger) mark(e LedgerEntry) {
+	l.entries = append(l.entries, e)
+	if e.Decision == DecisionUndecided {
+		return
+	}
+	l.judged[ledgerKey{e.Section, e.PostID, e.URL}] = true
+	if e.Hash != "" && e.ImageAlone {
+		l.byHash[e.Hash] = e
+	}
+}
+
+func (l *Ledger) Close() error {
+	return l.f.Close()
+}
+
+// newLedgerEntry describes verdict v on post, or on its image at url with hash.
+func newLedgerEntry(post Post, url, hash, classifier string, v Verdict) LedgerEntry {
+	posted, _ := importTime(post.DateGMT)
+	return LedgerEntry{
+		Section:       post.Section,
+		PostID:        post.ID,
+		UserID:        post.UserId,
+		Author:        post.Author,
+		Hash:          hash,
+		URL:           url,
+		Decision:      v.Decision,
+		Category:      v.Category,
+		Confidence:    v.Confidence,
+		Rationale:     v.Rationale,
+		Classifier:    classifier,
+		PromptVersion: promptVersion,
+		PostedAt:      posted.UTC(),
+		JudgedAt:      time.Now().UTC(),
+	}
+}
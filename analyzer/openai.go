@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// OpenAIClassifier asks a vision model behind an OpenAI-compatible
+// /v1/chat/completions endpoint, such as Ollama or vLLM, with moderationPrompt.
+type OpenAIClassifier struct {
+	baseURL string
+	model   string
+	apiKey  string
+	client  *http.Client
+}
+
+// newOpenAIClassifier falls back to the environment variable OPENAI_API_KEY
+// when apiKey is empty; self-hosted servers usually need none.
+func newOpenAIClassifier(baseURL, model, apiKey string) (*OpenAIClassifier, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("openai classifier needs a base_url")
+	}
+	if model == "" {
+		return nil, fmt.Errorf("openai classifier needs a model")
+	}
+	if apiKey == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+	}
+	return &OpenAIClassifier{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		model:   model,
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: 2 * time.Minute},
+	}, nil
+}
+
+func (o *OpenAIClassifier) Name() string {
+	return "openai/" + o.model
+}
+
+type chatRequest struct {
+	Model          string          `json:"model"`
+	Messages       []chatMessage   `json:"messages"`
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+}
+
+type responseFormat struct {
+	Type       string     `json:"type"`
+	JSONSchema jsonSchema `json:"json_schema"`
+}
+
+type jsonSchema struct {
+	Name   string `json:"name"`
+	Schema any    `json:"schema"`
+	Strict bool   `json:"strict"`
+}
+
+type chatMessage struct {
+	Role    string     `json:"role"`
+	Content []chatPart `json:"content"`
+}
+
+type chatPart struct {
+	Type     string        `json:"type"`
+	Text     string        `json:"text,omitempty"`
+	ImageURL *chatImageURL `json:"image_url,omitempty"`
+}
+
+type chatImageURL struct {
+	URL string `json:"url"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+func (o *OpenAIClassifier) Classify(ctx context.Context, in Input) (Verdict, error) {
+	var parts []chatPart
+	if in.Image != nil {
+		dataURL := "data:" + in.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(in.Image)
+		parts = append(parts, chatPart{Type: "image_url", ImageURL: &chatImageURL{URL: dataURL}})
+	}
+	parts = append(parts, chatPart{Type: "text", Text: moderationPrompt(in)})
+	body, err := json.Marshal(chatRequest{
+		Model:    o.model,
+		Messages: []chatMessage{{Role: "user", Content: parts}},
+		ResponseFormat: &responseFormat{
+			Type:       "json_schema",
+			JSONSchema: jsonSchema{Name: "verdict", Schema: verdictSchema, Strict: true},
+		},
+	})
+	if err != nil {
+		return Verdict{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", o.baseURL+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return Verdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Verdict{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Verdict{}, fmt.Errorf("chat completion failed: %s: %s", resp.Status, truncate(string(b), 200))
+	}
+	var cr chatResponse
+	if err := json.Unmarshal(b, &cr); err != nil {
+		return Verdict{}, fmt.Errorf("failed to decode chat completion: %w", err)
+	}
+	if len(cr.Choices) == 0 {
+		return Verdict{}, fmt.Errorf("chat completion returned no choices")
+	}
+	return parseVerdict(cr.Choices[0].Message.Content), nil
+}
+
+// truncate shortens s to at most n bytes for error messages.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
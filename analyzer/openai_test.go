@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIClassifierRequest(t *testing.T) {
+	image := []byte("\x89PNG fake image")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding request: %v", err)
+			return
+		}
+		if req.Model != "llava" {
+			t.Errorf("model = %q", req.Model)
+		}
+		if req.ResponseFormat == nil || req.ResponseFormat.Type != "json_schema" || !req.ResponseFormat.JSONSchema.Strict {
+			t.Errorf("response_format = %+v", req.ResponseFormat)
+		}
+		if len(req.Messages) != 1 || len(req.Messages[0].Content) != 2 {
+			t.Errorf("messages = %+v", req.Messages)
+			return
+		}
+		parts := req.Messages[0].Content
+		if parts[0].Type != "image_url" || parts[0].ImageURL == nil ||
+			parts[0].ImageURL.URL != "data:image/png;base64,iVBORyBmYWtlIGltYWdl" {
+			t.Errorf("image part = %+v", parts[0])
+		}
+		if parts[1].Type != "text" || !strings.Contains(parts[1].Text, "a caption") {
+			t.Errorf("text part = %+v", parts[1])
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"{\"decision\":\"block\",\"category\":\"disturbing\",\"confidence\":0.8,\"rationale\":\"gore\"}"}}]}`))
+	}))
+	defer srv.Close()
+
+	o, err := newOpenAIClassifier(srv.URL+"/", "llava", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := o.Classify(context.Background(), Input{Image: image, MIMEType: "image/png", Text: "a caption"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Decision != DecisionBlock || v.Category != CategoryDisturbing || v.Confidence != 0.8 || v.Rationale != "gore" {
+		t.Errorf("verdict = %+v", v)
+	}
+}
+
+func TestOpenAIClassifierReplies(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantErr  string
+		decision Decision
+	}{
+		{name: "server error", status: 500, body: "model not loaded", wantErr: "500 Internal Server Error: model not loaded"},
+		{name: "unauthorized", status: 401, body: `{"error":"bad key"}`, wantErr: "401 Unauthorized"},
+		{name: "no choices", status: 200, body: `{"choices":[]}`, wantErr: "no choices"},
+		{name: "not json", status: 200, body: "<html>", wantErr: "failed to decode chat completion"},
+		{name: "malformed verdict", status: 200, body: `{"choices":[{"message":{"content":"Yes, block it"}}]}`, decision: DecisionUndecided},
+		{name: "allow", status: 200, body: `{"choices":[{"message":{"content":"{\"decision\":\"allow\",\"category\":\"none\",\"confidence\":0.9,\"rationale\":\"a cat\"}"}}]}`, decision: DecisionAllow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Authorization"); got != "" {
+					t.Errorf("Authorization = %q without a key", got)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			o, err := newOpenAIClassifier(srv.URL, "llava", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := o.Classify(context.Background(), Input{Text: "some text"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Decision != tt.decision {
+				t.Errorf("decision = %s, want %s", v.Decision, tt.decision)
+			}
+		})
+	}
+}
+
+func TestNewOpenAIClassifier(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "from-env")
+	if _, err := newOpenAIClassifier("", "llava", ""); err == nil {
+		t.Error("no error without a base URL")
+	}
+	if _, err := newOpenAIClassifier("http://localhost:11434", "", ""); err == nil {
+		t.Error("no error without a model")
+	}
+	o, err := newOpenAIClassifier("http://localhost:11434", "llava", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.apiKey != "from-env" {
+		t.Errorf("api key = %q, want it from OPENAI_API_KEY", o.apiKey)
+	}
+}
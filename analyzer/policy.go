@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// PolicyRule is one condition under which a user is blocked. Kind selects
+// which fields apply:
+//   - "count": at least MinFlagged flagged posts within the last Days days.
+//   - "share": flagged posts make up at least MinShare of the posts analyzed
+//     within the last Days days, once at least MinAnalyzed were analyzed.
+//   - "confidence": one flagged post judged with at least MinConfidence.
+//
+// Days counts back from now by when the posts were made, not when they were
+// judged, so a backlog judged in one run is not mistaken for a burst. Days
+// of 0 looks at the whole history.
+type PolicyRule struct {
+	Name          string  `json:"name"`
+	Kind          string  `json:"kind"`
+	Days          int     `json:"days"`
+	MinFlagged    int     `json:"min_flagged"`
+	MinShare      float64 `json:"min_share"`
+	MinAnalyzed   int     `json:"min_analyzed"`
+	MinConfidence float64 `json:"min_confidence"`
+}
+
+// PolicyConfig lists the rules in config.json. A user is blocked as soon as
+// any rule is met.
+type PolicyConfig struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// defaultPolicy blocks on a single post only when the classifier is all but
+// certain: vision models report 0.9 and above for many borderline calls, so
+// anything less sure has to repeat within a week.
+var defaultPolicy = PolicyConfig{Rules: []PolicyRule{
+	{Name: "confident", Kind: "confidence", MinConfidence: 0.97},
+	{Name: "repeat", Kind: "count", MinFlagged: 2, Days: 7},
+}}
+
+// validate reports rules with an unknown kind or thresholds that could never
+// or would always be met.
+func (p PolicyConfig) validate() error {
+	for i, r := range p.Rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		switch r.Kind {
+		case "count":
+			if r.MinFlagged < 1 {
+				return fmt.Errorf("policy rule %s: min_flagged must be at least 1", name)
+			}
+		case "share":
+			if r.MinShare <= 0 || r.MinShare > 1 {
+				return fmt.Errorf("policy rule %s: min_share must be in (0, 1]", name)
+			}
+		case "confidence":
+			if r.MinConfidence <= 0 || r.MinConfidence > 1 {
+				return fmt.Errorf("policy rule %s: min_confidence must be in (0, 1]", name)
+			}
+		default:
+			return fmt.Errorf("policy rule %s: unknown kind %q", name, r.Kind)
+		}
+	}
+	return nil
+}
+
+// postOutcome is the combined verdict history of one post.
+type postOutcome struct {
+	postedAt   time.Time
+	flagged    bool
+	confidence float64
+}
+
+// outcomesOf folds a user's ledger entries into one outcome per post: flagged
+// if any decided entry blocked, with that entry's highest confidence.
+// Undecided entries are left out. Entries recorded without the post's time
+// fall back to when they were judged.
+func outcomesOf(entries []LedgerEntry) []postOutcome {
+	type postKey struct {
+		section string
+		id      int
+	}
+	byPost := make(map[postKey]*postOutcome)
+	var order []postKey
+	for _, e := range entries {
+		if e.Decision == DecisionUndecided {
+			continue
+		}
+		k := postKey{e.Section, e.PostID}
+		o, ok := byPost[k]
+		if !ok {
+			o = &postOutcome{}
+			byPost[k] = o
+			order = append(order, k)
+		}
+		at := e.PostedAt
+		if at.IsZero() {
+			at = e.JudgedAt
+		}
+		if at.After(o.postedAt) {
+			o.postedAt = at
+		}
+		if e.Decision == DecisionBlock {
+			o.flagged = true
+			o.confidence = max(o.confidence, e.Confidence)
+		}
+	}
+	out := make([]postOutcome, 0, len(order))
+	for _, k := range order {
+		out = append(out, *byPost[k])
+	}
+	return out
+}
+
+// evaluate returns the first rule the history meets and how, or false when
+// none is met.
+func (p PolicyConfig) evaluate(history []postOutcome, now time.Time) (PolicyRule, string, bool) {
+	for _, r := range p.Rules {
+		analyzed, flagged := 0, 0
+		best := 0.0
+		for _, o := range history {
+			if r.Days > 0 && now.Sub(o.postedAt) > time.Duration(r.Days)*24*time.Hour {
+				continue
+			}
+			analyzed++
+			if o.flagged {
+				flagged++
+				best = max(best, o.confidence)
+			}
+		}
+		switch r.Kind {
+		case "count":
+			if flagged >= r.MinFlagged {
+				return r, fmt.Sprintf("%d flagged posts%s", flagged, within(r.Days)), true
+			}
+		case "share":
+			if analyzed > 0 && analyzed >= r.MinAnalyzed && float64(flagged)/float64(analyzed) >= r.MinShare {
+				return r, fmt.Sprintf("%d of %d analyzed posts flagged%s", flagged, analyzed, within(r.Days)), true
+			}
+		case "confidence":
+			if flagged > 0 && best >= r.MinConfidence {
+				return r, fmt.Sprintf("a post flagged with confidence %.2f%s", best, within(r.Days)), true
+			}
+		}
+	}
+	return PolicyRule{}, "", false
+}
+
+func within(days int) string {
+	if days <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" within %d days", days)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(d float64) time.Time { return now.Add(-time.Duration(d * 24 * float64(time.Hour))) }
+	// entry is a verdict on post id, posted and judged the given days ago.
+	entry := func(id int, d Decision, confidence, posted, judged float64) LedgerEntry {
+		return LedgerEntry{Section: "pic", PostID: id, Decision: d, Confidence: confidence, PostedAt: daysAgo(posted), JudgedAt: daysAgo(judged)}
+	}
+	share := PolicyConfig{Rules: []PolicyRule{{Name: "share", Kind: "share", MinShare: 0.5, MinAnalyzed: 4, Days: 30}}}
+	tests := []struct {
+		name       string
+		policy     PolicyConfig
+		entries    []LedgerEntry
+		wantRule   string
+		wantDetail string
+	}{
+		{
+			name:   "no history",
+			policy: defaultPolicy,
+		},
+		{
+			name:       "one confident block",
+			policy:     defaultPolicy,
+			entries:    []LedgerEntry{entry(1, DecisionBlock, 0.98, 1, 0)},
+			wantRule:   "confident",
+			wantDetail: "a post flagged with confidence 0.98",
+		},
+		{
+			name:    "one block below the confidence threshold",
+			policy:  defaultPolicy,
+			entries: []LedgerEntry{entry(1, DecisionBlock, 0.9, 1, 0), entry(2, DecisionAllow, 1, 2, 0)},
+		},
+		{
+			name:       "two blocks posted within a week",
+			policy:     defaultPolicy,
+			entries:    []LedgerEntry{entry(1, DecisionBlock, 0.8, 1, 1), entry(2, DecisionBlock, 0.7, 6, 6)},
+			wantRule:   "repeat",
+			wantDetail: "2 flagged posts within 7 days",
+		},
+		{
+			name:    "two blocks judged together but posted over a week apart",
+			policy:  defaultPolicy,
+			entries: []LedgerEntry{entry(1, DecisionBlock, 0.8, 2, 0), entry(2, DecisionBlock, 0.8, 10, 0)},
+		},
+		{
+			name:    "two blocked images of one post",
+			policy:  defaultPolicy,
+			entries: []LedgerEntry{entry(1, DecisionBlock, 0.8, 1, 0), entry(1, DecisionBlock, 0.6, 1, 0)},
+		},
+		{
+			name:    "undecided verdicts left out",
+			policy:  defaultPolicy,
+			entries: []LedgerEntry{entry(1, DecisionBlock, 0.8, 1, 0), entry(2, DecisionUndecided, 1, 1, 0)},
+		},
+		{
+			name:   "entries without a post time fall back to the judging time",
+			policy: defaultPolicy,
+			entries: []LedgerEntry{
+				{Section: "pic", PostID: 1, Decision: DecisionBlock, Confidence: 0.8, JudgedAt: daysAgo(1)},
+				{Section: "pic", PostID: 2, Decision: DecisionBlock, Confidence: 0.8, JudgedAt: daysAgo(3)},
+			},
+			wantRule:   "repeat",
+			wantDetail: "2 flagged posts within 7 days",
+		},
+		{
+			name:   "share met",
+			policy: share,
+			entries: []LedgerEntry{
+				entry(1, DecisionBlock, 0.6, 1, 0), entry(2, DecisionBlock, 0.6, 2, 0),
+				entry(3, DecisionAllow, 1, 3, 0), entry(4, DecisionAllow, 1, 4, 0),
+				entry(5, DecisionAllow, 1, 40, 0),
+			},
+			wantRule:   "share",
+			wantDetail: "2 of 4 analyzed posts flagged within 30 days",
+		},
+		{
+			name:   "share with too few posts analyzed",
+			policy: share,
+			entries: []LedgerEntry{
+				entry(1, DecisionBlock, 0.6, 1, 0), entry(2, DecisionBlock, 0.6, 2, 0), entry(3, DecisionAllow, 1, 3, 0),
+			},
+		},
+		{
+			name:    "share below the minimum",
+			policy:  share,
+			entries: []LedgerEntry{entry(1, DecisionBlock, 0.6, 1, 0), entry(2, DecisionAllow, 1, 2, 0), entry(3, DecisionAllow, 1, 3, 0), entry(4, DecisionAllow, 1, 4, 0)},
+		},
+		{
+			name:    "confident block outside its window",
+			policy:  PolicyConfig{Rules: []PolicyRule{{Name: "recent", Kind: "confidence", MinConfidence: 0.9, Days: 1}}},
+			entries: []LedgerEntry{entry(1, DecisionBlock, 0.95, 3, 0)},
+		},
+		{
+			name: "first rule met wins",
+			policy: PolicyConfig{Rules: []PolicyRule{
+				{Name: "never", Kind: "count", MinFlagged: 5},
+				{Name: "once", Kind: "count", MinFlagged: 1},
+				{Name: "sure", Kind: "confidence", MinConfidence: 0.5},
+			}},
+			entries:    []LedgerEntry{entry(1, DecisionBlock, 0.95, 100, 0)},
+			wantRule:   "once",
+			wantDetail: "1 flagged posts",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, detail, met := tt.policy.evaluate(outcomesOf(tt.entries), now)
+			if met != (tt.wantRule != "") || rule.Name != tt.wantRule || detail != tt.wantDetail {
+				t.Errorf("evaluate = %q, %q, %v; want %q, %q", rule.Name, detail, met, tt.wantRule, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		rule PolicyRule
+		ok   bool
+	}{
+		{PolicyRule{Kind: "count", MinFlagged: 1}, true},
+		{PolicyRule{Kind: "count"}, false},
+		{PolicyRule{Kind: "share", MinShare: 1}, true},
+		{PolicyRule{Kind: "share", MinShare: 1.5}, false},
+		{PolicyRule{Kind: "confidence", MinConfidence: 0.97}, true},
+		{PolicyRule{Kind: "confidence"}, false},
+		{PolicyRule{Kind: "votes"}, false},
+	}
+	for _, tt := range tests {
+		err := PolicyConfig{Rules: []PolicyRule{tt.rule}}.validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("validate(%+v) = %v, want ok %v", tt.rule, err, tt.ok)
+		}
+	}
+	if err := defaultPolicy.validate(); err != nil {
+		t.Errorf("default policy: %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"purify/common/content"
+	"purify/common/fetch"
+	"purify/common/phash"
+)
+
+// ImageVerdict is the verdict on one image of a post.
+type ImageVerdict struct {
+	URL     string
+	Verdict Verdict
+	// Reason explains a blocking verdict.
+	Reason string
+}
+
+// PostVerdict combines the verdicts on every image of a post, or is the
+// verdict on its text when none of its images could be judged.
+type PostVerdict struct {
+	Verdict Verdict
+	Images  []ImageVerdict
+	// Reason explains a blocking verdict by its first blocking image or its text.
+	Reason string
+}
+
+// postAnalyzer judges every image of a post, checking each against the
+// flagged images before asking the classifier.
+type postAnalyzer struct {
+	cls          Classifier
+	knownBad     *KnownBad
+	pathKnownBad string
+	maxDistance  int
+	imageHashes  *phash.Index
+	thread       ThreadConfig
+	threads      threadIndex
+	stats        contextStats
+	ledger       *Ledger
+	// force re-judges what the ledger already holds a verdict for.
+	force bool
+}
+
+// analyze judges the images of post, each together with the post's text, or
+// the text alone when no image could be judged. Images that fail to download
+// or are not in a classifiable format are left out, as is anything the ledger
+// already holds a verdict for unless forced. It reports false when there
+// was nothing new to judge. Classifier errors are returned so the caller can stop
+// spending requests.
+func (a *postAnalyzer) analyze(ctx context.Context, post Post) (PostVerdict, bool, error) {
+	var pv PostVerdict
+	var verdicts []Verdict
+	judgedBefore := false
+	for _, img := range post.Images {
+		if a.judged(post, img.Large) {
+			judgedBefore = true
+			continue
+		}
+		iv, ok, err := a.analyzeImage(ctx, post, img)
+		if err != nil {
+			return pv, false, err
+		}
+		if !ok {
+			continue
+		}
+		pv.Images = append(pv.Images, iv)
+		verdicts = append(verdicts, iv.Verdict)
+		if pv.Reason == "" && iv.Verdict.Blocks() {
+			pv.Reason = iv.Reason
+		}
+	}
+	if len(pv.Images) == 0 {
+		if judgedBefore {
+			return pv, false, nil
+		}
+		return a.analyzeText(ctx, post)
+	}
+	pv.Verdict = combineVerdicts(verdicts)
+	return pv, true, nil
+}
+
+// analyzeText judges the text of post on its own.
+func (a *postAnalyzer) analyzeText(ctx context.Context, post Post) (PostVerdict, bool, error) {
+	var pv PostVerdict
+	if post.Text == "" || a.judged(post, "") {
+		return pv, false, nil
+	}
+	v, err := a.classify(ctx, Input{Text: post.Text, Post: post})
+	if err != nil {
+		return pv, false, err
+	}
+	a.record(newLedgerEntry(post, "", "", a.cls.Name(), v))
+	pv.Verdict = v
+	if v.Blocks() {
+		pv.Reason = fmt.Sprintf("text of post %d (%s) flagged by %s as %s", post.ID, post.Section, a.cls.Name(), v.Category)
+	}
+	return pv, true, nil
+}
+
+func (a *postAnalyzer) analyzeImage(ctx context.Context, post Post, img content.Image) (ImageVerdict, bool, error) {
+	url := img.Large
+	iv := ImageVerdict{URL: url}
+	data, err := fetch.DownloadImage(limiter, url)
+	if err != nil {
+		log.Printf("%v", err)
+		return iv, false, nil
+	}
+	mimeType := sniffMIME(data)
+	if mimeType == "" {
+		log.Printf("Skipping %s: not a classifiable image", url)
+		return iv, false, nil
+	}
+
+	h, hashErr := phash.Compute(data)
+	hash := ""
+	if hashErr == nil {
+		hash = h.String()
+		if err := a.imageHashes.Add(phash.Entry{Section: post.Section, PostID: post.ID, URL: url, Hash: h}); err != nil {
+			log.Printf("Failed to index image hash: %v", err)
+		}
+		// An image that blocks on its own blocks this post whatever it says;
+		// otherwise the verdict only carries over when nothing else is sent.
+		if prior, ok := a.judgedImage(hash); ok && (prior.Decision == DecisionBlock || a.alone(post)) {
+			iv.Verdict = Verdict{Decision: prior.Decision, Category: prior.Category, Confidence: prior.Confidence, Rationale: prior.Rationale}
+			if iv.Verdict.Blocks() {
+				iv.Reason = fmt.Sprintf("image %s is the image of post %d (%s) flagged by %s as %s", url, prior.PostID, prior.Section, prior.Classifier, prior.Category)
+			}
+			e := newLedgerEntry(post, url, hash, prior.Classifier, iv.Verdict)
+			e.ImageAlone = true
+			a.record(e)
+			return iv, true, nil
+		}
+		if flagged, dist, ok := a.knownBad.match(h, a.maxDistance); ok {
+			category := flagged.Category
+			if category == "" {
+				category = CategoryControversial
+			}
+			iv.Reason = fmt.Sprintf("image %s is a near-duplicate (distance %d) of %s flagged in post %d (%s)", url, dist, flagged.URL, flagged.PostID, flagged.Section)
+			// A copy is as certain as the original flag, so blocking on it
+			// still goes through the same policy rules.
+			iv.Verdict = Verdict{Decision: DecisionBlock, Category: category, Confidence: flagged.Confidence, Rationale: iv.Reason}
+			e := newLedgerEntry(post, url, hash, "known-bad", iv.Verdict)
+			e.ImageAlone = true
+			a.record(e)
+			return iv, true, nil
+		}
+	}
+
+	frames := framesOf(data, mimeType)
+	if len(frames) == 0 {
+		log.Printf("Skipping %s: %s does not decode", url, mimeType)
+		return iv, false, nil
+	}
+	iv.Verdict, err = a.classifyFrames(ctx, post, frames, true)
+	if err != nil {
+		return iv, false, err
+	}
+	// A block may rest on the post's text or thread alone, so the image is
+	// judged once more without them before it counts as bad wherever it shows.
+	alone := a.alone(post)
+	if !alone && iv.Verdict.Blocks() {
+		v, err := a.classifyFrames(ctx, post, frames, false)
+		if err != nil {
+			return iv, false, err
+		}
+		alone = v.Blocks()
+	}
+	e := newLedgerEntry(post, url, hash, a.cls.Name(), iv.Verdict)
+	e.ImageAlone = alone
+	a.record(e)
+	if !iv.Verdict.Blocks() {
+		return iv, true, nil
+	}
+	iv.Reason = fmt.Sprintf("image %s in post %d (%s) flagged by %s as %s", url, post.ID, post.Section, a.cls.Name(), iv.Verdict.Category)
+	if hashErr == nil && alone {
+		flagged := FlaggedImage{Hash: h, Section: post.Section, PostID: post.ID, URL: url, Category: iv.Verdict.Category, Confidence: iv.Verdict.Confidence, FlaggedAt: time.Now().UTC()}
+		if err := a.knownBad.add(a.pathKnownBad, flagged); err != nil {
+			log.Printf("Failed to save flagged image: %v", err)
+		}
+	}
+	return iv, true, nil
+}
+
+// classifyFrames judges the frames of an image together, with the post's text
+// and thread or, without withPost, on their own.
+func (a *postAnalyzer) classifyFrames(ctx context.Context, post Post, frames []frame, withPost bool) (Verdict, error) {
+	var verdicts []Verdict
+	for _, f := range frames {
+		var v Verdict
+		var err error
+		if withPost {
+			v, err = a.classify(ctx, Input{Image: f.data, MIMEType: f.mimeType, Text: post.Text, Post: post})
+		} else {
+			v, err = a.cls.Classify(ctx, Input{Image: f.data, MIMEType: f.mimeType, Post: post})
+		}
+		if err != nil {
+			return Verdict{}, err
+		}
+		verdicts = append(verdicts, v)
+	}
+	return combineVerdicts(verdicts), nil
+}
+
+// alone reports whether the images of post are sent without text or thread.
+func (a *postAnalyzer) alone(post Post) bool {
+	return post.Text == "" && a.thread.MaxReplies < 0
+}
+
+// judged reports whether the ledger holds a verdict on the text (ref "") or
+// the image at URL ref of post and it should not be judged again.
+func (a *postAnalyzer) judged(post Post, ref string) bool {
+	if a.force || !a.ledger.judgedRef(post.Section, post.ID, ref) {
+		return false
+	}
+	what := "text"
+	if ref != "" {
+		what = "image " + ref
+	}
+	fmt.Printf("Post ID: %d %s was judged before, skipping.\n", post.ID, what)
+	return true
+}
+
+// judgedImage returns the ledger's verdict on an image with hash on its own,
+// shown by this post or any other, unless forced to judge it again.
+func (a *postAnalyzer) judgedImage(hash string) (LedgerEntry, bool) {
+	if a.force {
+		return LedgerEntry{}, false
+	}
+	prior, ok := a.ledger.byImageHash(hash)
+	if ok {
+		fmt.Printf("Image %s was judged in post %d (%s), reusing its verdict.\n", hash, prior.PostID, prior.Section)
+	}
+	return prior, ok
+}
+
+func (a *postAnalyzer) record(e LedgerEntry) {
+	if err := a.ledger.record(e); err != nil {
+		log.Printf("Failed to record verdict in ledger: %v", err)
+	}
+}
+
+// combineVerdicts merges the verdicts on the parts of something into one: the
+// most confident block if any part blocks, otherwise undecided if any part is,
+// otherwise the least confident allow. No verdicts at all is undecided.
+func combineVerdicts(vs []Verdict) Verdict {
+	var block, undecided, allow *Verdict
+	for i := range vs {
+		v := &vs[i]
+		switch v.Decision {
+		case DecisionBlock:
+			if block == nil || v.Confidence > block.Confidence {
+				block = v
+			}
+		case DecisionAllow:
+			if allow == nil || v.Confidence < allow.Confidence {
+				allow = v
+			}
+		default:
+			if undecided == nil {
+				undecided = v
+			}
+		}
+	}
+	switch {
+	case block != nil:
+		return *block
+	case undecided != nil:
+		return *undecided
+	case allow != nil:
+		return *allow
+	}
+	return Verdict{Decision: DecisionUndecided, Rationale: "nothing to judge"}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ThreadConfig sets what thread context is sent with each post.
+type ThreadConfig struct {
+	// MaxReplies bounds how many replies are sent; negative sends no context.
+	MaxReplies int `json:"max_replies"`
+	// Measure classifies every input a second time without context and
+	// reports how often the context changed the verdict. It doubles the cost.
+	Measure bool `json:"measure"`
+}
+
+// maxReplyRunes bounds the text sent of each reply.
+const maxReplyRunes = 200
+
+// PostContext is what the comment thread says about a post.
+type PostContext struct {
+	VotePositive int
+	VoteNegative int
+	// Replies are a sample of the tucao replies, most voted first.
+	Replies []Reply
+}
+
+// Reply is one tucao reply in a PostContext.
+type Reply struct {
+	Author       string
+	Text         string
+	VotePositive int
+	VoteNegative int
+}
+
+// threadIndex finds the replies to posts among the loaded posts.
+type threadIndex map[threadKey][]Post
+
+type threadKey struct {
+	section string
+	id      int
+}
+
+func newThreadIndex(posts []Post) threadIndex {
+	idx := make(threadIndex)
+	for _, p := range posts {
+		if p.ParentID != 0 {
+			k := threadKey{p.Section, p.ParentID}
+			idx[k] = append(idx[k], p)
+		}
+	}
+	return idx
+}
+
+// contextOf returns the context of post with at most maxReplies replies,
+// preferring the ones with the most votes.
+func (idx threadIndex) contextOf(post Post, maxReplies int) *PostContext {
+	pc := &PostContext{VotePositive: post.VotePositive, VoteNegative: post.VoteNegative}
+	replies := append([]Post(nil), idx[threadKey{post.Section, post.ID}]...)
+	sort.SliceStable(replies, func(i, j int) bool {
+		return replies[i].VotePositive+replies[i].VoteNegative > replies[j].VotePositive+replies[j].VoteNegative
+	})
+	for _, r := range replies {
+		if len(pc.Replies) >= maxReplies {
+			break
+		}
+		if r.Text == "" {
+			continue
+		}
+		pc.Replies = append(pc.Replies, Reply{
+			Author:       r.Author,
+			Text:         truncateRunes(r.Text, maxReplyRunes),
+			VotePositive: r.VotePositive,
+			VoteNegative: r.VoteNegative,
+		})
+	}
+	return pc
+}
+
+// prompt describes the context for moderationPrompt.
+func (pc *PostContext) prompt() string {
+	var b strings.Builder
+	b.WriteString("\nThread context, to judge whether the post set off a dispute:\n")
+	fmt.Fprintf(&b, "Votes: %d positive, %d negative.\n", pc.VotePositive, pc.VoteNegative)
+	if len(pc.Replies) == 0 {
+		b.WriteString("No replies.\n")
+		return b.String()
+	}
+	b.WriteString("Replies:\n")
+	for _, r := range pc.Replies {
+		fmt.Fprintf(&b, "- %s (+%d/-%d): %s\n", r.Author, r.VotePositive, r.VoteNegative, strings.ReplaceAll(r.Text, "\n", " "))
+	}
+	return b.String()
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
+
+// contextStats counts how often thread context changed a verdict.
+type contextStats struct {
+	compared int
+	changed  map[[2]Decision]int
+}
+
+// classify asks a.cls about in, adding the thread context of its post unless
+// context is disabled. When measuring, it also asks without context and
+// tallies whether the decision differs; the verdict with context is returned.
+func (a *postAnalyzer) classify(ctx context.Context, in Input) (Verdict, error) {
+	if a.thread.MaxReplies < 0 {
+		return a.cls.Classify(ctx, in)
+	}
+	withCtx := in
+	withCtx.Context = a.threads.contextOf(in.Post, a.thread.MaxReplies)
+	v, err := a.cls.Classify(ctx, withCtx)
+	if err != nil || !a.thread.Measure {
+		return v, err
+	}
+	bare, err := a.cls.Classify(ctx, in)
+	if err != nil {
+		return v, err
+	}
+	a.stats.compared++
+	if bare.Decision != v.Decision {
+		if a.stats.changed == nil {
+			a.stats.changed = make(map[[2]Decision]int)
+		}
+		a.stats.changed[[2]Decision{bare.Decision, v.Decision}]++
+		fmt.Printf("  context changed post %d from %s to %s\n", in.Post.ID, bare.Decision, v.Decision)
+	}
+	return v, nil
+}
+
+// report prints how much context changed the verdicts measured.
+func (s contextStats) report() {
+	if s.compared == 0 {
+		return
+	}
+	total := 0
+	for _, n := range s.changed {
+		total += n
+	}
+	fmt.Printf("Thread context changed %d of %d verdicts (%.0f%%)\n", total, s.compared, 100*float64(total)/float64(s.compared))
+	changes := make([][2]Decision, 0, len(s.changed))
+	for change := range s.changed {
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i][0] < changes[j][0] || changes[i][0] == changes[j][0] && changes[i][1] < changes[j][1]
+	})
+	for _, change := range changes {
+		fmt.Printf("  %s -> %s: %d\n", change[0], change[1], s.changed[change])
+	}
+}
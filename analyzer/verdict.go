@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Decision is what a classifier concluded about an input.
+type Decision string
+
+const (
+	DecisionBlock Decision = "block"
+	DecisionAllow Decision = "allow"
+	// DecisionUndecided is given when the reply could not be understood.
+	DecisionUndecided Decision = "undecided"
+)
+
+// The categories of moderationPrompt, and "none" for allowed content.
+const (
+	CategorySocialMediaDrama = "social_media_drama"
+	CategoryGenderConflict   = "gender_conflict"
+	CategoryDisturbing       = "disturbing"
+	CategoryControversial    = "controversial"
+	CategoryNone             = "none"
+)
+
+var categories = []string{CategorySocialMediaDrama, CategoryGenderConflict, CategoryDisturbing, CategoryControversial, CategoryNone}
+
+// verdictSchema is the JSON schema classifiers are asked to answer in.
+var verdictSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"decision":   map[string]any{"type": "string", "enum": []string{string(DecisionBlock), string(DecisionAllow)}},
+		"category":   map[string]any{"type": "string", "enum": categories},
+		"confidence": map[string]any{"type": "number", "minimum": 0, "maximum": 1},
+		"rationale":  map[string]any{"type": "string"},
+	},
+	"required":             []string{"decision", "category", "confidence", "rationale"},
+	"additionalProperties": false,
+}
+
+// verdictInstructions ends every prompt so models without schema support
+// still answer in the expected shape.
+const verdictInstructions = `
+Answer with a single JSON object and nothing else:
+{"decision": "block" or "allow", "category": one of "social_media_drama" (condition 1), "gender_conflict" (condition 2), "disturbing" (condition 3), "controversial" (condition 4) or "none", "confidence": a number from 0 to 1, "rationale": one short sentence}
+Use "block" when at least one condition is satisfied, naming the best matching category.`
+
+// parseVerdict strictly parses a classifier reply in verdictSchema. Replies
+// that do not parse or break the schema give an undecided verdict that keeps
+// the raw text.
+func parseVerdict(raw string) Verdict {
+	v, err := decodeVerdict(raw)
+	if err != nil {
+		return Verdict{Decision: DecisionUndecided, Rationale: err.Error(), Raw: raw}
+	}
+	v.Raw = raw
+	return v
+}
+
+func decodeVerdict(raw string) (Verdict, error) {
+	var reply struct {
+		Decision   *string  `json:"decision"`
+		Category   *string  `json:"category"`
+		Confidence *float64 `json:"confidence"`
+		Rationale  *string  `json:"rationale"`
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(stripCodeFence(raw))))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&reply); err != nil {
+		return Verdict{}, fmt.Errorf("malformed verdict: %w", err)
+	}
+	if dec.More() {
+		return Verdict{}, fmt.Errorf("malformed verdict: trailing data")
+	}
+	if reply.Decision == nil || reply.Category == nil || reply.Confidence == nil || reply.Rationale == nil {
+		return Verdict{}, fmt.Errorf("malformed verdict: missing field")
+	}
+	d := Decision(*reply.Decision)
+	if d != DecisionBlock && d != DecisionAllow {
+		return Verdict{}, fmt.Errorf("malformed verdict: unknown decision %q", d)
+	}
+	if !validCategory(*reply.Category) {
+		return Verdict{}, fmt.Errorf("malformed verdict: unknown category %q", *reply.Category)
+	}
+	if c := *reply.Confidence; c < 0 || c > 1 {
+		return Verdict{}, fmt.Errorf("malformed verdict: confidence %v out of range", c)
+	}
+	return Verdict{Decision: d, Category: *reply.Category, Confidence: *reply.Confidence, Rationale: *reply.Rationale}, nil
+}
+
+func validCategory(c string) bool {
+	for _, known := range categories {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// stripCodeFence removes the ```json fence some models wrap JSON in.
+func stripCodeFence(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") {
+		return s
+	}
+	s = strings.TrimPrefix(s, "```")
+	s = strings.TrimPrefix(s, "json")
+	return strings.TrimSpace(strings.TrimSuffix(s, "```"))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseVerdict(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want Verdict
+		// wantErr is part of the rationale of an undecided verdict.
+		wantErr string
+	}{
+		{
+			name: "block",
+			raw:  `{"decision":"block","category":"gender_conflict","confidence":0.85,"rationale":"mocks women"}`,
+			want: Verdict{Decision: DecisionBlock, Category: CategoryGenderConflict, Confidence: 0.85, Rationale: "mocks women"},
+		},
+		{
+			name: "allow",
+			raw:  `{"decision":"allow","category":"none","confidence":0.95,"rationale":"a landscape"}`,
+			want: Verdict{Decision: DecisionAllow, Category: CategoryNone, Confidence: 0.95, Rationale: "a landscape"},
+		},
+		{
+			name: "code fence",
+			raw:  "```json\n{\"decision\":\"block\",\"category\":\"disturbing\",\"confidence\":1,\"rationale\":\"gore\"}\n```",
+			want: Verdict{Decision: DecisionBlock, Category: CategoryDisturbing, Confidence: 1, Rationale: "gore"},
+		},
+		{
+			name: "bare code fence",
+			raw:  "```\n{\"decision\":\"allow\",\"category\":\"none\",\"confidence\":0,\"rationale\":\"\"}\n```",
+			want: Verdict{Decision: DecisionAllow, Category: CategoryNone},
+		},
+		{
+			name: "surrounding whitespace",
+			raw:  "\n  {\"decision\":\"allow\",\"category\":\"none\",\"confidence\":0.5,\"rationale\":\"ok\"}  \n",
+			want: Verdict{Decision: DecisionAllow, Category: CategoryNone, Confidence: 0.5, Rationale: "ok"},
+		},
+		{
+			name:    "free text",
+			raw:     "No, although the eyes look a little unsettling, yes it is fine.",
+			wantErr: "malformed verdict",
+		},
+		{
+			name:    "unknown field",
+			raw:     `{"decision":"allow","category":"none","confidence":0.5,"rationale":"ok","severity":3}`,
+			wantErr: `unknown field "severity"`,
+		},
+		{
+			name:    "missing field",
+			raw:     `{"decision":"block","category":"disturbing","rationale":"gore"}`,
+			wantErr: "missing field",
+		},
+		{
+			name:    "null field",
+			raw:     `{"decision":"block","category":null,"confidence":0.5,"rationale":"gore"}`,
+			wantErr: "missing field",
+		},
+		{
+			name:    "confidence above 1",
+			raw:     `{"decision":"block","category":"disturbing","confidence":1.5,"rationale":"gore"}`,
+			wantErr: "out of range",
+		},
+		{
+			name:    "confidence below 0",
+			raw:     `{"decision":"allow","category":"none","confidence":-0.1,"rationale":"ok"}`,
+			wantErr: "out of range",
+		},
+		{
+			name:    "confidence as string",
+			raw:     `{"decision":"allow","category":"none","confidence":"high","rationale":"ok"}`,
+			wantErr: "malformed verdict",
+		},
+		{
+			name:    "trailing data",
+			raw:     `{"decision":"allow","category":"none","confidence":0.5,"rationale":"ok"} {"decision":"block"}`,
+			wantErr: "trailing data",
+		},
+		{
+			name:    "trailing text",
+			raw:     `{"decision":"allow","category":"none","confidence":0.5,"rationale":"ok"} yes`,
+			wantErr: "trailing data",
+		},
+		{
+			name:    "unknown decision",
+			raw:     `{"decision":"yes","category":"disturbing","confidence":0.5,"rationale":"gore"}`,
+			wantErr: `unknown decision "yes"`,
+		},
+		{
+			name:    "undecided is not a reply",
+			raw:     `{"decision":"undecided","category":"none","confidence":0.5,"rationale":"unsure"}`,
+			wantErr: "unknown decision",
+		},
+		{
+			name:    "unknown category",
+			raw:     `{"decision":"block","category":"politics","confidence":0.5,"rationale":"flags"}`,
+			wantErr: `unknown category "politics"`,
+		},
+		{
+			name:    "empty",
+			raw:     "",
+			wantErr: "malformed verdict",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseVerdict(tt.raw)
+			if got.Raw != tt.raw {
+				t.Errorf("Raw = %q, want the reply as received", got.Raw)
+			}
+			if tt.wantErr != "" {
+				if got.Decision != DecisionUndecided || !strings.Contains(got.Rationale, tt.wantErr) {
+					t.Errorf("got %s verdict %q, want undecided with %q", got.Decision, got.Rationale, tt.wantErr)
+				}
+				if got.Blocks() {
+					t.Errorf("malformed reply blocks")
+				}
+				return
+			}
+			got.Raw = ""
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+// Package archive keeps local copies of the images crawled comments refer to,
+// so the evidence behind a block survives the image leaving the CDN. Images
+// are stored once per content under their SHA-256 and an index maps each
+// comment to the images it showed.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config enables and bounds the archive in config.json.
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Dir is relative to the repository root unless absolute.
+	Dir string `json:"dir"`
+	// MaxMB caps the total size of stored images; 0 means no cap.
+	MaxMB int64 `json:"max_mb"`
+	// RetentionDays is how long an image is kept after it was last archived;
+	// 0 keeps images until the size cap evicts them.
+	RetentionDays int `json:"retention_days"`
+}
+
+const defaultDir = "media"
+
+// Entry records that the comment ID of Section showed the image at URL,
+// stored as the blob SHA256.
+type Entry struct {
+	Section    string    `json:"section"`
+	PostID     int       `json:"post_id"`
+	URL        string    `json:"url"`
+	SHA256     string    `json:"sha256"`
+	Size       int64     `json:"size"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// Archive is a content-addressed image store. A nil *Archive archives nothing.
+type Archive struct {
+	mu        sync.Mutex
+	dir       string
+	maxBytes  int64
+	retention time.Duration
+	index     *os.File
+	entries   []Entry
+	// byURL maps an archived URL to its blob so it is not downloaded again.
+	byURL map[string]string
+}
+
+// Open opens the configured archive, resolving a relative directory against
+// root. It returns nil when the archive is disabled.
+func Open(cfg Config, root string) (*Archive, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	dir := cfg.Dir
+	if dir == "" {
+		dir = defaultDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "blobs"), 0o755); err != nil {
+		return nil, err
+	}
+	a := &Archive{
+		dir:       dir,
+		maxBytes:  cfg.MaxMB << 20,
+		retention: time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		byURL:     make(map[string]string),
+	}
+	entries, err := readIndex(a.indexPath())
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		a.add(e)
+	}
+	if err := a.openIndex(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Get returns the archived image at url, if there is one.
+func (a *Archive) Get(url string) ([]byte, bool) {
+	if a == nil {
+		return nil, false
+	}
+	a.mu.Lock()
+	sha, ok := a.byURL[url]
+	a.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(a.BlobPath(sha))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Put stores data as the image at url shown by a comment and returns its
+// SHA-256. Content already archived is recorded for the comment without being
+// written again.
+func (a *Archive) Put(section string, postID int, url string, data []byte) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+	sum := sha256.Sum256(data)
+	e := Entry{
+		Section:    section,
+		PostID:     postID,
+		URL:        url,
+		SHA256:     hex.EncodeToString(sum[:]),
+		Size:       int64(len(data)),
+		ArchivedAt: time.Now().UTC(),
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.writeBlob(e.SHA256, data); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	if _, err := a.index.Write(append(b, '\n')); err != nil {
+		return "", err
+	}
+	a.add(e)
+	return e.SHA256, nil
+}
+
+// Lookup returns the images archived for a comment.
+func (a *Archive) Lookup(section string, postID int) []Entry {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []Entry
+	for _, e := range a.entries {
+		if e.Section == section && e.PostID == postID {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// BlobPath returns where the blob with the given SHA-256 is stored.
+func (a *Archive) BlobPath(sha string) string {
+	return filepath.Join(a.dir, "blobs", sha[:2], sha)
+}
+
+// Prune deletes the blobs last archived longer than the retention ago, then
+// the least recently archived ones until the total fits the size cap. It
+// returns how many blobs were deleted and how many bytes they held.
+func (a *Archive) Prune(now time.Time) (int, int64, error) {
+	if a == nil {
+		return 0, 0, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	type blob struct {
+		sha  string
+		size int64
+		last time.Time
+	}
+	bySHA := make(map[string]*blob)
+	var total int64
+	for _, e := range a.entries {
+		b, ok := bySHA[e.SHA256]
+		if !ok {
+			b = &blob{sha: e.SHA256, size: e.Size}
+			bySHA[e.SHA256] = b
+			total += e.Size
+		}
+		if e.ArchivedAt.After(b.last) {
+			b.last = e.ArchivedAt
+		}
+	}
+	blobs := make([]*blob, 0, len(bySHA))
+	for _, b := range bySHA {
+		blobs = append(blobs, b)
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].last.Before(blobs[j].last) })
+
+	drop := make(map[string]bool)
+	var freed int64
+	for _, b := range blobs {
+		expired := a.retention > 0 && now.Sub(b.last) > a.retention
+		over := a.maxBytes > 0 && total > a.maxBytes
+		if !expired && !over {
+			break
+		}
+		if err := os.Remove(a.BlobPath(b.sha)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return len(drop), freed, err
+		}
+		drop[b.sha] = true
+		total -= b.size
+		freed += b.size
+	}
+	if len(drop) == 0 {
+		return 0, 0, nil
+	}
+
+	kept := a.entries[:0]
+	for _, e := range a.entries {
+		if !drop[e.SHA256] {
+			kept = append(kept, e)
+		}
+	}
+	a.entries = kept
+	for url, sha := range a.byURL {
+		if drop[sha] {
+			delete(a.byURL, url)
+		}
+	}
+	if err := a.rewriteIndex(); err != nil {
+		return len(drop), freed, err
+	}
+	return len(drop), freed, nil
+}
+
+func (a *Archive) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.index.Close()
+}
+
+func (a *Archive) add(e Entry) {
+	a.entries = append(a.entries, e)
+	a.byURL[e.URL] = e.SHA256
+}
+
+func (a *Archive) indexPath() string {
+	return filepath.Join(a.dir, "index.jsonl")
+}
+
+func (a *Archive) openIndex() error {
+	f, err := os.OpenFile(a.indexPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	a.index = f
+	return nil
+}
+
+// writeBlob stores data under sha unless an identical blob is already there.
+func (a *Archive) writeBlob(sha string, data []byte) error {
+	path := a.BlobPath(sha)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// rewriteIndex atomically replaces the index with the current entries and
+// reopens it for appending.
+func (a *Archive) rewriteIndex() error {
+	if err := a.index.Close(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, e := range a.entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	tmp := a.indexPath() + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, a.indexPath()); err != nil {
+		return err
+	}
+	return a.openIndex()
+}
+
+func readIndex(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []Entry
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
@@ -0,0 +1,257 @@
+// Package content parses the HTML of a comment into the text, media, links
+// and mentions it holds, so tools downstream of the crawler need not parse
+// markup themselves.
+package content
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Parsed is the structured form of a comment's HTML.
+type Parsed struct {
+	// Text is the visible text with line breaks kept and markup removed.
+	Text     string    `json:"text,omitempty"`
+	Images   []Image   `json:"images,omitempty"`
+	Videos   []string  `json:"videos,omitempty"`
+	Links    []string  `json:"links,omitempty"`
+	Mentions []Mention `json:"mentions,omitempty"`
+}
+
+// IsZero reports whether nothing was parsed.
+func (p Parsed) IsZero() bool {
+	return p.Text == "" && len(p.Images) == 0 && len(p.Videos) == 0 && len(p.Links) == 0 && len(p.Mentions) == 0
+}
+
+// Image is one image of a comment in the sizes the image host serves.
+// Hosts without known sizes get the same URL in every field.
+type Image struct {
+	Original  string `json:"original"`
+	Large     string `json:"large"`
+	Thumbnail string `json:"thumbnail"`
+}
+
+// Mention is an @reference to another user. CommentID is the comment or reply
+// it links to, 0 when the mention is plain text.
+type Mention struct {
+	Author    string `json:"author"`
+	CommentID int    `json:"comment_id,omitempty"`
+}
+
+// Image sizes are a path segment on the Sina-style hosts jandan uses, such as
+// https://wx1.sinaimg.cn/mw600/abc.jpg.
+var (
+	imageSizeRe = regexp.MustCompile(`^/(large|mw\d+|bmiddle|orj\d+|thumb\d+|square|small)/`)
+	// mentionRe finds @name in plain text; names end at whitespace or a colon.
+	mentionRe = regexp.MustCompile(`(?:^|\s)@([^\s:：@]+)`)
+	// anchorIDRe finds the comment ID in a reply link such as #tucao-5412345.
+	anchorIDRe = regexp.MustCompile(`^#(?:tucao|comment)-(\d+)$`)
+)
+
+// Parse extracts the structure of a comment's HTML. Markup it cannot make
+// sense of is skipped rather than reported.
+func Parse(s string) Parsed {
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return Parsed{Text: strings.TrimSpace(s)}
+	}
+	p := &parser{seen: make(map[string]bool)}
+	p.walk(doc)
+	p.out.Text = strings.TrimSpace(collapseBlankLines(p.text.String()))
+	return p.out
+}
+
+type parser struct {
+	out  Parsed
+	text strings.Builder
+	seen map[string]bool
+	// original is the full-size link that precedes an image on jandan,
+	// waiting for the <img> it belongs to.
+	original string
+}
+
+func (p *parser) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		p.text.WriteString(n.Data)
+		p.plainMentions(n.Data)
+		return
+	case html.ElementNode:
+		switch n.DataAtom {
+		case atom.Br:
+			p.text.WriteByte('\n')
+			return
+		case atom.Img:
+			p.image(attr(n, "src"))
+			return
+		case atom.Video, atom.Source, atom.Iframe, atom.Embed:
+			p.video(attr(n, "src"))
+		case atom.Script, atom.Style:
+			return
+		case atom.A:
+			if p.anchor(n) {
+				return
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		p.walk(c)
+	}
+	if n.Type == html.ElementNode && (n.DataAtom == atom.P || n.DataAtom == atom.Div) {
+		p.text.WriteByte('\n')
+	}
+}
+
+// anchor records what a link stands for and reports whether its children
+// have been handled.
+func (p *parser) anchor(n *html.Node) bool {
+	href := attr(n, "href")
+	label := strings.TrimSpace(nodeText(n))
+	if m := anchorIDRe.FindStringSubmatch(href); m != nil || strings.HasPrefix(label, "@") {
+		mention := Mention{Author: strings.TrimPrefix(label, "@")}
+		if m != nil {
+			mention.CommentID, _ = strconv.Atoi(m[1])
+		}
+		if mention.Author != "" {
+			p.out.Mentions = append(p.out.Mentions, mention)
+		}
+		// The @ often sits in the text just before the link.
+		if !strings.HasSuffix(p.text.String(), "@") {
+			p.text.WriteByte('@')
+		}
+		p.text.WriteString(mention.Author)
+		return true
+	}
+	if strings.Contains(attr(n, "class"), "view_img_link") || isImageURL(href) {
+		// The [查看原图] link points at the full-size version of the next image;
+		// a link wrapping an image points at that image only.
+		p.original = absolute(href)
+		if hasImage(n) {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				p.walk(c)
+			}
+			p.original = ""
+		}
+		return true
+	}
+	if u := absolute(href); strings.HasPrefix(u, "http") && !p.seen[u] {
+		p.seen[u] = true
+		p.out.Links = append(p.out.Links, u)
+	}
+	return false
+}
+
+func (p *parser) image(src string) {
+	src = absolute(src)
+	if src == "" {
+		return
+	}
+	img := imageSizes(src)
+	if p.original != "" {
+		img.Original = p.original
+		p.original = ""
+	}
+	if p.seen[img.Original] {
+		return
+	}
+	p.seen[img.Original] = true
+	p.out.Images = append(p.out.Images, img)
+}
+
+func (p *parser) video(src string) {
+	src = absolute(src)
+	if src == "" || p.seen[src] {
+		return
+	}
+	p.seen[src] = true
+	p.out.Videos = append(p.out.Videos, src)
+}
+
+func (p *parser) plainMentions(s string) {
+	for _, m := range mentionRe.FindAllStringSubmatch(s, -1) {
+		p.out.Mentions = append(p.out.Mentions, Mention{Author: m[1]})
+	}
+}
+
+// imageSizes derives the variants of an image from one of its URLs.
+func imageSizes(src string) Image {
+	u, err := url.Parse(src)
+	if err != nil || !imageSizeRe.MatchString(u.Path) {
+		return Image{Original: src, Large: src, Thumbnail: src}
+	}
+	sized := func(size string) string {
+		v := *u
+		v.Path = imageSizeRe.ReplaceAllString(u.Path, "/"+size+"/")
+		return v.String()
+	}
+	return Image{Original: sized("large"), Large: sized("mw600"), Thumbnail: sized("thumb180")}
+}
+
+func isImageURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	path := strings.ToLower(u.Path)
+	for _, ext := range []string{".jpg", ".jpeg", ".png", ".gif", ".webp"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// absolute makes protocol-relative URLs such as //wx1.sinaimg.cn/... usable.
+func absolute(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "//") {
+		return "https:" + s
+	}
+	return s
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// hasImage reports whether an <img> is nested anywhere inside n.
+func hasImage(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Img || hasImage(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+var blankLinesRe = regexp.MustCompile(`\n[ \t]*\n(?:[ \t]*\n)+`)
+
+// collapseBlankLines keeps at most one empty line between paragraphs.
+func collapseBlankLines(s string) string {
+	return blankLinesRe.ReplaceAllString(s, "\n\n")
+}
@@ -0,0 +1,77 @@
+package content
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	sina := func(name string) Image {
+		return Image{
+			Original:  "https://wx1.sinaimg.cn/large/" + name,
+			Large:     "https://wx1.sinaimg.cn/mw600/" + name,
+			Thumbnail: "https://wx1.sinaimg.cn/thumb180/" + name,
+		}
+	}
+	tests := []struct {
+		name string
+		html string
+		want Parsed
+	}{
+		{
+			name: "empty",
+			html: "",
+			want: Parsed{},
+		},
+		{
+			name: "paragraphs and breaks",
+			html: "<p>one</p><p></p><p></p><p>two<br>three</p><script>alert(1)</script>",
+			want: Parsed{Text: "one\n\ntwo\nthree"},
+		},
+		{
+			name: "view original link before image",
+			html: `<a href="//wx1.sinaimg.cn/large/a.jpg" target="_blank" class="view_img_link">[查看原图]</a><br /><img src="//wx1.sinaimg.cn/mw600/a.jpg" />`,
+			want: Parsed{Images: []Image{sina("a.jpg")}},
+		},
+		{
+			name: "link wrapping an image",
+			html: `<a href=https://img.example/orig/a.jpg><img src=https://img.example/pics/a.jpg></a><img src=https://img.example/pics/b.jpg>`,
+			want: Parsed{Images: []Image{
+				{Original: "https://img.example/orig/a.jpg", Large: "https://img.example/pics/a.jpg", Thumbnail: "https://img.example/pics/a.jpg"},
+				{Original: "https://img.example/pics/b.jpg", Large: "https://img.example/pics/b.jpg", Thumbnail: "https://img.example/pics/b.jpg"},
+			}},
+		},
+		{
+			name: "sized image without link",
+			html: `<img src="//wx1.sinaimg.cn/mw600/b.gif"><img src="//wx1.sinaimg.cn/thumb180/b.gif">`,
+			want: Parsed{Images: []Image{sina("b.gif")}},
+		},
+		{
+			name: "reply mention",
+			html: `<a href="#tucao-5412345">@bob</a>: agreed`,
+			want: Parsed{Text: "@bob: agreed", Mentions: []Mention{{Author: "bob", CommentID: 5412345}}},
+		},
+		{
+			name: "plain mention",
+			html: "@carol look at this",
+			want: Parsed{Text: "@carol look at this", Mentions: []Mention{{Author: "carol"}}},
+		},
+		{
+			name: "links once each",
+			html: `<a href="https://example.com/x">x</a> and <a href="https://example.com/x">again</a> <a href="/local">here</a>`,
+			want: Parsed{Text: "x and again here", Links: []string{"https://example.com/x"}},
+		},
+		{
+			name: "video",
+			html: `<video controls><source src="//v.example/a.mp4"></video>`,
+			want: Parsed{Videos: []string{"https://v.example/a.mp4"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.html); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q)\n got %+v\nwant %+v", tt.html, got, tt.want)
+			}
+		})
+	}
+}
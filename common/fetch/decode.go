@@ -0,0 +1,118 @@
+// Package fetch holds the HTTP helpers shared by the crawler and the analyzer.
+package fetch
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// AcceptEncoding lists every content coding NewDecoder can undo.
+const AcceptEncoding = "gzip, deflate, br, zstd"
+
+// ErrUnsupportedEncoding is returned for a Content-Encoding NewDecoder does not know.
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// ReadBody reads the body of r and undoes its Content-Encoding.
+func ReadBody(r *http.Response) ([]byte, error) {
+	body, err := NewDecoder(r.Body, r.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// NewDecoder wraps body so that reading from it undoes contentEncoding. A list
+// of codings is undone in the reverse of the order they were applied in.
+func NewDecoder(body io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	codings := strings.Split(contentEncoding, ",")
+	var closers []io.Closer
+	r := body
+	for i := len(codings) - 1; i >= 0; i-- {
+		next, closer, err := decodeOne(r, strings.ToLower(strings.TrimSpace(codings[i])))
+		if err != nil {
+			for _, c := range closers {
+				c.Close()
+			}
+			return nil, err
+		}
+		r = next
+		if closer != nil {
+			closers = append(closers, closer)
+		}
+	}
+	return &decoder{Reader: r, closers: closers}, nil
+}
+
+func decodeOne(r io.Reader, coding string) (io.Reader, io.Closer, error) {
+	switch coding {
+	case "", "identity":
+		return r, nil, nil
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gr, gr, nil
+	case "deflate":
+		return newDeflateReader(r)
+	case "br":
+		return brotli.NewReader(r), nil, nil
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, closerFunc(zr.Close), nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnsupportedEncoding, coding)
+	}
+}
+
+// newDeflateReader handles "deflate" as specified (zlib wrapped) as well as the
+// raw deflate stream some servers send instead.
+func newDeflateReader(r io.Reader) (io.Reader, io.Closer, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		zr, err := zlib.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr, nil
+	}
+	fr := flate.NewReader(br)
+	return fr, fr, nil
+}
+
+type closerFunc func()
+
+func (f closerFunc) Close() error {
+	f()
+	return nil
+}
+
+type decoder struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decoder) Close() error {
+	var err error
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		if cerr := d.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
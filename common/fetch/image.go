@@ -0,0 +1,36 @@
+package fetch
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// imageClient is the client DownloadImage uses; images can be large and slow.
+var imageClient = &http.Client{Timeout: 60 * time.Second}
+
+// DownloadImage fetches the image at url through l, undoing any content
+// encoding, and fails on responses other than 200.
+func DownloadImage(l *Limiter, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %v", url, err)
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7")
+	req.Header.Set("Accept-Encoding", AcceptEncoding)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36")
+	resp, err := l.Do(imageClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image from %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download image from %s: %s", url, resp.Status)
+	}
+
+	b, err := ReadBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image body from %s: %v", url, err)
+	}
+	return b, nil
+}
@@ -0,0 +1,135 @@
+package fetch
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMinRate is the floor request rate in requests per second.
+	DefaultMinRate = 0.2
+	// DefaultMaxRate is the ceiling request rate in requests per second.
+	DefaultMaxRate = 4
+	// slowLatency is the response time above which the rate is eased off.
+	slowLatency = 2 * time.Second
+)
+
+// LimiterConfig sets the floor and ceiling of a Limiter in requests per second.
+type LimiterConfig struct {
+	MinRate float64 `json:"min_rate"`
+	MaxRate float64 `json:"max_rate"`
+}
+
+// Limiter paces requests at a rate it adapts to the server: the rate creeps
+// up while responses are fast and successful, is cut on slow responses and
+// errors, and halves on 429 or 503. It always stays between the configured
+// floor and ceiling. A nil *Limiter does not limit anything.
+type Limiter struct {
+	mu      sync.Mutex
+	minRate float64
+	maxRate float64
+	rate    float64
+	next    time.Time
+	latency time.Duration
+}
+
+// NewLimiter returns a Limiter starting at one request per second, clamped to
+// cfg. Zero fields of cfg fall back to DefaultMinRate and DefaultMaxRate.
+func NewLimiter(cfg LimiterConfig) *Limiter {
+	if cfg.MinRate <= 0 {
+		cfg.MinRate = DefaultMinRate
+	}
+	if cfg.MaxRate <= 0 {
+		cfg.MaxRate = DefaultMaxRate
+	}
+	if cfg.MaxRate < cfg.MinRate {
+		cfg.MaxRate = cfg.MinRate
+	}
+	l := &Limiter{minRate: cfg.MinRate, maxRate: cfg.MaxRate}
+	l.rate = l.clamp(1)
+	return l
+}
+
+// Wait blocks until the next request may be sent or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(time.Duration(float64(time.Second) / l.rate))
+	l.mu.Unlock()
+
+	d := time.Until(at)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Observe adjusts the rate to the outcome of a request. status is 0 when the
+// request failed without a response.
+func (l *Limiter) Observe(latency time.Duration, status int, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.latency == 0 {
+		l.latency = latency
+	} else {
+		l.latency = (l.latency*4 + latency) / 5
+	}
+	switch {
+	case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+		l.rate = l.clamp(l.rate / 2)
+	case err != nil || status >= 500:
+		l.rate = l.clamp(l.rate * 0.75)
+	case l.latency > slowLatency:
+		l.rate = l.clamp(l.rate * 0.9)
+	default:
+		l.rate = l.clamp(l.rate + (l.maxRate-l.minRate)/20)
+	}
+}
+
+// Rate returns the current rate in requests per second.
+func (l *Limiter) Rate() float64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Do waits for a slot, sends req with client and feeds the outcome back.
+func (l *Limiter) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if err := l.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	l.Observe(time.Since(start), status, err)
+	return resp, err
+}
+
+func (l *Limiter) clamp(rate float64) float64 {
+	return min(max(rate, l.minRate), l.maxRate)
+}
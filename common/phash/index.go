@@ -0,0 +1,168 @@
+package phash
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var indexHeader = []string{"section", "post_id", "url", "phash", "seen_at"}
+
+// Entry is the hash of one image shown by a comment.
+type Entry struct {
+	Section string
+	PostID  int
+	URL     string
+	Hash    Hash
+	SeenAt  time.Time
+}
+
+// Match is an indexed image near a queried hash.
+type Match struct {
+	Entry
+	Distance int
+}
+
+// Index keeps the hashes of every downloaded image in an append-only CSV file
+// and answers near-duplicate queries from memory. A nil *Index records nothing.
+type Index struct {
+	mu      sync.Mutex
+	f       *os.File
+	w       *csv.Writer
+	entries []Entry
+	seen    map[string]bool
+}
+
+// OpenIndex opens or creates the index at path.
+func OpenIndex(path string) (*Index, error) {
+	entries, err := readIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, _ := f.Stat()
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		_ = w.Write(indexHeader)
+		w.Flush()
+	}
+	idx := &Index{f: f, w: w, entries: entries, seen: make(map[string]bool)}
+	for _, e := range entries {
+		idx.seen[entryKey(e)] = true
+	}
+	return idx, w.Error()
+}
+
+// Add records e unless the same image of the same comment is already indexed.
+func (idx *Index) Add(e Entry) error {
+	if idx == nil {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	k := entryKey(e)
+	if idx.seen[k] {
+		return nil
+	}
+	if e.SeenAt.IsZero() {
+		e.SeenAt = time.Now().UTC()
+	}
+	_ = idx.w.Write([]string{e.Section, strconv.Itoa(e.PostID), e.URL, e.Hash.String(), e.SeenAt.Format(time.RFC3339)})
+	idx.w.Flush()
+	if err := idx.w.Error(); err != nil {
+		return err
+	}
+	idx.seen[k] = true
+	idx.entries = append(idx.entries, e)
+	return nil
+}
+
+// ByPost returns the hashes indexed for a comment ID in any section.
+func (idx *Index) ByPost(postID int) []Entry {
+	if idx == nil {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	var out []Entry
+	for _, e := range idx.entries {
+		if e.PostID == postID {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Near returns the indexed images at most maxDistance bits from h, closest first.
+func (idx *Index) Near(h Hash, maxDistance int) []Match {
+	if idx == nil {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	var out []Match
+	for _, e := range idx.entries {
+		if d := Distance(h, e.Hash); d <= maxDistance {
+			out = append(out, Match{Entry: e, Distance: d})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Distance < out[j].Distance })
+	return out
+}
+
+func (idx *Index) Close() error {
+	if idx == nil {
+		return nil
+	}
+	idx.w.Flush()
+	return idx.f.Close()
+}
+
+func entryKey(e Entry) string {
+	return e.Section + "\x00" + strconv.Itoa(e.PostID) + "\x00" + e.URL
+}
+
+func readIndex(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	var entries []Entry
+	for line := 0; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 0 || len(row) < len(indexHeader) {
+			continue
+		}
+		id, err := strconv.Atoi(row[1])
+		if err != nil {
+			continue
+		}
+		h, err := ParseHash(row[3])
+		if err != nil {
+			continue
+		}
+		seenAt, _ := time.Parse(time.RFC3339, row[4])
+		entries = append(entries, Entry{Section: row[0], PostID: id, URL: row[2], Hash: h, SeenAt: seenAt})
+	}
+	return entries, nil
+}
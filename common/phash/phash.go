@@ -0,0 +1,106 @@
+// Package phash computes perceptual hashes of images, so reposts of the same
+// picture can be found even after resizing or recompression.
+package phash
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"strconv"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Hash is a 64-bit difference hash (dHash). Similar images have hashes a
+// small Hamming distance apart.
+type Hash uint64
+
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// ParseHash parses the form String returns.
+func ParseHash(s string) (Hash, error) {
+	v, err := strconv.ParseUint(s, 16, 64)
+	return Hash(v), err
+}
+
+// MarshalText encodes h as String does, so hashes read well in JSON.
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *Hash) UnmarshalText(b []byte) error {
+	v, err := ParseHash(string(b))
+	if err != nil {
+		return err
+	}
+	*h = v
+	return nil
+}
+
+// Distance returns the number of bits in which a and b differ.
+func Distance(a, b Hash) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+// Compute decodes a JPEG, PNG, GIF or WebP image and returns its hash. Only the
+// first frame of an animated GIF is hashed.
+func Compute(data []byte) (Hash, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	return DHash(img), nil
+}
+
+// DHash shrinks img to 9x8 grey cells and sets one bit per cell that is
+// brighter than its right neighbour.
+func DHash(img image.Image) Hash {
+	const w, h = 9, 8
+	var grey [h][w]float64
+	b := img.Bounds()
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(b.Min.X+(x+1)*b.Dx()/w, x0+1)
+			grey[y][x] = meanLuma(img, x0, y0, x1, y1)
+		}
+	}
+	var hash Hash
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if grey[y][x] > grey[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// meanLuma averages the luma of the pixels in [x0,x1)x[y0,y1), sampling at
+// most 16 pixels per side so large images stay cheap.
+func meanLuma(img image.Image, x0, y0, x1, y1 int) float64 {
+	stepX := max((x1-x0)/16, 1)
+	stepY := max((y1-y0)/16, 1)
+	var sum float64
+	n := 0
+	for y := y0; y < y1; y += stepY {
+		for x := x0; x < x1; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
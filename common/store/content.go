@@ -0,0 +1,79 @@
+package store
+
+import (
+	"errors"
+	"html"
+	"strings"
+)
+
+// contentColumn names the content column of files in the current encoding.
+const contentColumn = "content_v2"
+
+// contentEncoding is one version of how the content column of a CSV file is
+// encoded. The version is told by the column's name in the header. Files are
+// only written in the current one; older ones are read to upgrade them.
+type contentEncoding struct {
+	column string
+	decode func(string) string
+}
+
+// contentEncodings maps content column names to their encodings.
+var contentEncodings = map[string]contentEncoding{
+	// The first files wrote newlines as a literal \n and then HTML-escaped
+	// the content. That does not round-trip a real backslash-n, so decoding
+	// it is a best effort.
+	"content": {
+		column: "content",
+		decode: func(s string) string {
+			return strings.ReplaceAll(html.UnescapeString(s), `\n`, "\n")
+		},
+	},
+	// Backslash escapes for backslash, newline and carriage return keep every
+	// row on one line and decode back to exactly what was stored.
+	contentColumn: {
+		column: contentColumn,
+		decode: unescapeContent,
+	},
+}
+
+var errNoContentColumn = errors.New("header has no known content column")
+
+// encodingOf returns the content encoding named by header.
+func encodingOf(header []string) (contentEncoding, error) {
+	for _, name := range header {
+		if enc, ok := contentEncodings[name]; ok {
+			return enc, nil
+		}
+	}
+	return contentEncoding{}, errNoContentColumn
+}
+
+var contentEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+func escapeContent(s string) string {
+	return contentEscaper.Replace(s)
+}
+
+func unescapeContent(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
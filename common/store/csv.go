@@ -0,0 +1,368 @@
+package store
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// csvHeader is the header row of user_activity.csv. The name of the content
+// column records how its values are encoded, see contentEncodings.
+var csvHeader = []string{"id", "author", "user_id", "date_gmt", "vote_negative", "vote_positive", contentColumn, "section", "parent_id", "parsed"}
+
+// csvDefaults fills columns missing from rows written by older versions.
+var csvDefaults = map[string]string{
+	"section":   DefaultSection,
+	"parent_id": "0",
+	"parsed":    "",
+}
+
+var _ Store = (*CSVStore)(nil)
+
+// CSVStore keeps records in a CSV file, appending new ones and rewriting the
+// file for vote updates. The keys of stored records are held in memory.
+type CSVStore struct {
+	path string
+	f    *os.File
+	w    *csv.Writer
+	keys map[key]struct{}
+	// upgrade is how opening the store upgraded the file, nil if it did not.
+	upgrade *CSVUpgrade
+}
+
+// CSVUpgrade describes how UpgradeCSV changed a file.
+type CSVUpgrade struct {
+	// Columns is the header of the upgraded file.
+	Columns []string
+	// ReencodedFrom names the content column the file was re-encoded from,
+	// empty when its content was already in the current encoding.
+	ReencodedFrom string
+}
+
+// OpenCSV opens or creates a CSV store, upgrading files written by older
+// versions, see UpgradeCSV.
+func OpenCSV(path string) (*CSVStore, error) {
+	upgrade, err := UpgradeCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &CSVStore{path: path, keys: make(map[key]struct{}), upgrade: upgrade}
+	rows, err := s.readRows()
+	if err != nil {
+		return nil, err
+	}
+	recs, _, err := decodeRows(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, rec := range recs {
+		s.keys[keyOf(rec)] = struct{}{}
+	}
+	if err := s.openAppend(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *CSVStore) openAppend() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, _ := f.Stat()
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		_ = w.Write(csvHeader)
+		w.Flush()
+	}
+	s.f, s.w = f, w
+	return w.Error()
+}
+
+func (s *CSVStore) Has(section string, id int) (bool, error) {
+	_, ok := s.keys[key{section: section, id: id}]
+	return ok, nil
+}
+
+func (s *CSVStore) InsertIfAbsent(rec Record) (bool, error) {
+	k := keyOf(rec)
+	if _, ok := s.keys[k]; ok {
+		return false, nil
+	}
+	row, err := encodeCSVRecord(rec)
+	if err != nil {
+		return false, err
+	}
+	if err := s.w.Write(row); err != nil {
+		return false, err
+	}
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		return false, err
+	}
+	s.keys[k] = struct{}{}
+	return true, nil
+}
+
+func (s *CSVStore) UpsertVotes(votes []Votes) (int, error) {
+	byKey := votesByKey(votes)
+	rows, err := s.readRows()
+	if err != nil {
+		return 0, err
+	}
+	sectionCol := slices.Index(rows[0], "section")
+	updated := 0
+	for _, row := range rows[1:] {
+		id, err := strconv.Atoi(row[0])
+		if err != nil {
+			continue
+		}
+		section := csvDefaults["section"]
+		if sectionCol >= 0 && sectionCol < len(row) {
+			section = row[sectionCol]
+		}
+		v, ok := byKey[key{section: section, id: id}]
+		if !ok {
+			continue
+		}
+		neg, pos := strconv.Itoa(v.VoteNegative), strconv.Itoa(v.VotePositive)
+		if row[4] != neg || row[5] != pos {
+			row[4], row[5] = neg, pos
+			updated++
+		}
+	}
+	if updated == 0 {
+		return 0, nil
+	}
+	if err := s.rewrite(rows); err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+func (s *CSVStore) QueryByTimeRange(from, to time.Time) ([]Record, error) {
+	return s.query(func(rec Record) bool { return inRange(rec, from, to) })
+}
+
+func (s *CSVStore) QueryByUser(userID int, author string) ([]Record, error) {
+	return s.query(func(rec Record) bool { return byUser(rec, userID, author) })
+}
+
+// Upgrade returns how opening the store upgraded its file, or nil if the file
+// was current.
+func (s *CSVStore) Upgrade() *CSVUpgrade {
+	return s.upgrade
+}
+
+func (s *CSVStore) Close() error {
+	s.w.Flush()
+	return s.f.Close()
+}
+
+func (s *CSVStore) query(match func(Record) bool) ([]Record, error) {
+	s.w.Flush()
+	recs, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+	var out []Record
+	for _, rec := range recs {
+		if match(rec) {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+// readRows reads the raw rows of the file including the header.
+func (s *CSVStore) readRows() ([][]string, error) {
+	if s.w != nil {
+		s.w.Flush()
+	}
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return [][]string{csvHeader}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return [][]string{csvHeader}, nil
+	}
+	return rows, nil
+}
+
+func (s *CSVStore) readAll() ([]Record, error) {
+	rows, err := s.readRows()
+	if err != nil {
+		return nil, err
+	}
+	recs, _, err := decodeRows(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	return recs, nil
+}
+
+// decodeRows turns the rows of a file into records, returning the content
+// encoding its header names.
+func decodeRows(rows [][]string) ([]Record, contentEncoding, error) {
+	enc, err := encodingOf(rows[0])
+	if err != nil {
+		return nil, enc, err
+	}
+	// Columns are looked up by header name so older files with fewer columns still load.
+	col := make(map[string]int)
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+	field := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return csvDefaults[name]
+	}
+	recs := make([]Record, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		if len(row) < 7 {
+			continue // skip incomplete rows
+		}
+		id, err := strconv.Atoi(field(row, "id"))
+		if err != nil {
+			continue
+		}
+		userID, _ := strconv.Atoi(field(row, "user_id"))
+		voteNeg, _ := strconv.Atoi(field(row, "vote_negative"))
+		votePos, _ := strconv.Atoi(field(row, "vote_positive"))
+		parentID, _ := strconv.Atoi(field(row, "parent_id"))
+		parsed, _ := decodeParsed(field(row, "parsed"))
+		recs = append(recs, Record{
+			ID:           id,
+			Author:       field(row, "author"),
+			UserID:       userID,
+			DateGMT:      field(row, "date_gmt"),
+			VoteNegative: voteNeg,
+			VotePositive: votePos,
+			Content:      enc.decode(field(row, enc.column)),
+			Section:      field(row, "section"),
+			ParentID:     parentID,
+			Parsed:       parsed,
+		})
+	}
+	return recs, enc, nil
+}
+
+// rewrite replaces the file with rows and reopens it for appending.
+func (s *CSVStore) rewrite(rows [][]string) error {
+	s.w.Flush()
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	if err := writeCSVFile(s.path, rows); err != nil {
+		return err
+	}
+	return s.openAppend()
+}
+
+func encodeCSVRecord(rec Record) ([]string, error) {
+	parsed, err := encodeParsed(rec.Parsed)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		strconv.Itoa(rec.ID),
+		rec.Author,
+		strconv.Itoa(rec.UserID),
+		rec.DateGMT,
+		strconv.Itoa(rec.VoteNegative),
+		strconv.Itoa(rec.VotePositive),
+		escapeContent(rec.Content),
+		rec.Section,
+		strconv.Itoa(rec.ParentID),
+		parsed,
+	}, nil
+}
+
+// UpgradeCSV rewrites a CSV file written by an older version: missing
+// columns are filled from csvDefaults and content in an older encoding is
+// re-encoded in the current one. It returns what changed, or nil when the
+// file is missing or current.
+func UpgradeCSV(path string) (*CSVUpgrade, error) {
+	in, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	in.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	enc, err := encodingOf(rows[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(rows[0]) >= len(csvHeader) && enc.column == contentColumn {
+		return nil, nil
+	}
+	var upgrade CSVUpgrade
+	if len(rows[0]) < len(csvHeader) {
+		rows[0] = append(rows[0], csvHeader[len(rows[0]):]...)
+		for i := 1; i < len(rows); i++ {
+			for len(rows[i]) < len(csvHeader) {
+				rows[i] = append(rows[i], csvDefaults[csvHeader[len(rows[i])]])
+			}
+		}
+	}
+	if enc.column != contentColumn {
+		col := slices.Index(rows[0], enc.column)
+		rows[0][col] = contentColumn
+		for _, row := range rows[1:] {
+			if col < len(row) {
+				row[col] = escapeContent(enc.decode(row[col]))
+			}
+		}
+		upgrade.ReencodedFrom = enc.column
+	}
+	upgrade.Columns = rows[0]
+	if err := writeCSVFile(path, rows); err != nil {
+		return nil, err
+	}
+	return &upgrade, nil
+}
+
+// writeCSVFile atomically replaces path with rows.
+func writeCSVFile(path string, rows [][]string) error {
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(out)
+	if err := w.WriteAll(rows); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
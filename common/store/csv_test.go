@@ -0,0 +1,116 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// awkwardContent holds everything the legacy encoding mangled.
+var awkwardContent = []string{
+	`a literal \n stays two characters`,
+	`a backslash \\ and a trailing backslash \`,
+	"real\nnewlines\r\nand a lone \r carriage return",
+	"&amp; &lt;already escaped&gt; & <b>raw</b>",
+	`commas, "quotes", and ""doubled"" quotes`,
+	"",
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user_activity.csv")
+	s, err := OpenCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range awkwardContent {
+		if _, err := s.InsertIfAbsent(Record{ID: i + 1, Section: "pic", DateGMT: "2025-12-11T10:14:36+08:00", Content: c}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Upgrade() != nil {
+		t.Errorf("current file upgraded: %+v", s.Upgrade())
+	}
+	checkContent(t, s, awkwardContent)
+}
+
+func TestCSVUpgradeLegacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user_activity.csv")
+	legacy := "id,author,user_id,date_gmt,vote_negative,vote_positive,content\n" +
+		`1,alice,7,2025-12-11 10:14:36,1,2,line one\nline two &amp;amp; &lt;b&gt;` + "\n"
+	if err := os.WriteFile(path, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := s.Upgrade()
+	if u == nil || u.ReencodedFrom != "content" || !slices.Equal(u.Columns, csvHeader) {
+		t.Fatalf("upgrade = %+v, want columns %v re-encoded from content", u, csvHeader)
+	}
+	// Rows added after the upgrade are written losslessly too.
+	for i, c := range awkwardContent {
+		if _, err := s.InsertIfAbsent(Record{ID: i + 2, Section: "pic", DateGMT: "2025-12-11T10:14:36+08:00", Content: c}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(csvHeader, ","); !strings.HasPrefix(string(header), want+"\n") {
+		t.Errorf("header = %q, want %q", strings.SplitN(string(header), "\n", 2)[0], want)
+	}
+
+	s, err = OpenCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Upgrade() != nil {
+		t.Errorf("upgraded file upgraded again: %+v", s.Upgrade())
+	}
+	checkContent(t, s, append([]string{"line one\nline two &amp; <b>"}, awkwardContent...))
+
+	recs, err := s.QueryByUser(7, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Section != DefaultSection || recs[0].VotePositive != 2 {
+		t.Errorf("legacy row = %+v", recs)
+	}
+}
+
+// checkContent checks that s holds records with IDs 1 onwards with content want.
+func checkContent(t *testing.T, s *CSVStore, want []string) {
+	t.Helper()
+	recs, err := s.QueryByTimeRange(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("read %d records, want %d", len(recs), len(want))
+	}
+	for i, rec := range recs {
+		if rec.ID != i+1 || rec.Content != want[i] {
+			t.Errorf("record %d content = %q, want %q", rec.ID, rec.Content, want[i])
+		}
+	}
+}
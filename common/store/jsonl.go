@@ -0,0 +1,191 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+var _ Store = (*JSONLStore)(nil)
+
+// JSONLStore keeps records as one JSON object per line, appending new ones and
+// rewriting the file for vote updates. The keys of stored records are held in memory.
+type JSONLStore struct {
+	path string
+	f    *os.File
+	keys map[key]struct{}
+}
+
+// OpenJSONL opens or creates a JSONL store.
+func OpenJSONL(path string) (*JSONLStore, error) {
+	s := &JSONLStore{path: path, keys: make(map[key]struct{})}
+	recs, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range recs {
+		s.keys[keyOf(rec)] = struct{}{}
+	}
+	if err := s.openAppend(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *JSONLStore) openAppend() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	return nil
+}
+
+func (s *JSONLStore) Has(section string, id int) (bool, error) {
+	_, ok := s.keys[key{section: section, id: id}]
+	return ok, nil
+}
+
+func (s *JSONLStore) InsertIfAbsent(rec Record) (bool, error) {
+	k := keyOf(rec)
+	if _, ok := s.keys[k]; ok {
+		return false, nil
+	}
+	b, err := marshalLine(rec)
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.f.Write(b); err != nil {
+		return false, err
+	}
+	s.keys[k] = struct{}{}
+	return true, nil
+}
+
+func (s *JSONLStore) UpsertVotes(votes []Votes) (int, error) {
+	byKey := votesByKey(votes)
+	recs, err := s.readAll()
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for i := range recs {
+		v, ok := byKey[keyOf(recs[i])]
+		if !ok {
+			continue
+		}
+		if recs[i].VoteNegative != v.VoteNegative || recs[i].VotePositive != v.VotePositive {
+			recs[i].VoteNegative, recs[i].VotePositive = v.VoteNegative, v.VotePositive
+			updated++
+		}
+	}
+	if updated == 0 {
+		return 0, nil
+	}
+	if err := s.rewrite(recs); err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+func (s *JSONLStore) QueryByTimeRange(from, to time.Time) ([]Record, error) {
+	return s.query(func(rec Record) bool { return inRange(rec, from, to) })
+}
+
+func (s *JSONLStore) QueryByUser(userID int, author string) ([]Record, error) {
+	return s.query(func(rec Record) bool { return byUser(rec, userID, author) })
+}
+
+func (s *JSONLStore) Close() error {
+	return s.f.Close()
+}
+
+func (s *JSONLStore) query(match func(Record) bool) ([]Record, error) {
+	recs, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+	var out []Record
+	for _, rec := range recs {
+		if match(rec) {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+func (s *JSONLStore) readAll() ([]Record, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var recs []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, err
+		}
+		if rec.Section == "" {
+			rec.Section = DefaultSection
+		}
+		recs = append(recs, rec)
+	}
+	return recs, scanner.Err()
+}
+
+// rewrite replaces the file with recs and reopens it for appending.
+func (s *JSONLStore) rewrite(recs []Record) error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	for _, rec := range recs {
+		b, err := marshalLine(rec)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		w.Write(b)
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	return s.openAppend()
+}
+
+// marshalLine encodes rec as one newline-terminated line, leaving the HTML in
+// its content readable.
+func marshalLine(rec Record) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(rec); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
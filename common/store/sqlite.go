@@ -0,0 +1,250 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the tables of a SQLite store. posted_at is date_gmt as
+// Unix seconds so time ranges compare correctly across zone offsets; it is
+// NULL for records whose date does not parse.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS comments (
+	section       TEXT    NOT NULL,
+	id            INTEGER NOT NULL,
+	author        TEXT    NOT NULL,
+	user_id       INTEGER NOT NULL,
+	date_gmt      TEXT    NOT NULL,
+	posted_at     INTEGER,
+	vote_negative INTEGER NOT NULL,
+	vote_positive INTEGER NOT NULL,
+	content       TEXT    NOT NULL,
+	parent_id     INTEGER NOT NULL,
+	parsed        TEXT    NOT NULL DEFAULT '',
+	PRIMARY KEY (section, id)
+);
+CREATE INDEX IF NOT EXISTS comments_id ON comments (id);
+CREATE INDEX IF NOT EXISTS comments_user_id ON comments (user_id);
+CREATE INDEX IF NOT EXISTS comments_date_gmt ON comments (posted_at);
+CREATE TABLE IF NOT EXISTS state (
+	name TEXT PRIMARY KEY,
+	data BLOB NOT NULL
+);`
+
+const sqliteColumns = "id, author, user_id, date_gmt, vote_negative, vote_positive, content, section, parent_id, parsed"
+
+// sqliteAddedColumns are the columns added to the comments table after it was
+// first released, with their definitions, so older databases can be upgraded.
+var sqliteAddedColumns = []struct{ name, def string }{
+	{"parsed", "TEXT NOT NULL DEFAULT ''"},
+}
+
+var (
+	_ Store         = (*SQLiteStore)(nil)
+	_ StateStore    = (*SQLiteStore)(nil)
+	_ BatchInserter = (*SQLiteStore)(nil)
+)
+
+// SQLiteStore keeps records in a SQLite database, indexed by ID, user and date.
+// It also keeps named crawler state such as the crawl history.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens or creates a SQLite store.
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// A single connection keeps writes serialized and the pragmas in effect.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema in %s: %w", path, err)
+	}
+	if err := upgradeSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to upgrade schema in %s: %w", path, err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Has(section string, id int) (bool, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM comments WHERE section = ? AND id = ?", section, id).Scan(&n)
+	return n > 0, err
+}
+
+func (s *SQLiteStore) InsertIfAbsent(rec Record) (bool, error) {
+	return insertSQLite(s.db, rec)
+}
+
+// InsertAll stores the absent records in one transaction.
+func (s *SQLiteStore) InsertAll(recs []Record) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	added := 0
+	for _, rec := range recs {
+		ok, err := insertSQLite(tx, rec)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			added++
+		}
+	}
+	return added, tx.Commit()
+}
+
+func (s *SQLiteStore) UpsertVotes(votes []Votes) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`UPDATE comments SET vote_negative = ?, vote_positive = ?
+		WHERE section = ? AND id = ? AND (vote_negative != ? OR vote_positive != ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	updated := 0
+	for _, v := range votes {
+		res, err := stmt.Exec(v.VoteNegative, v.VotePositive, v.Section, v.ID, v.VoteNegative, v.VotePositive)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		updated += int(n)
+	}
+	return updated, tx.Commit()
+}
+
+func (s *SQLiteStore) QueryByTimeRange(from, to time.Time) ([]Record, error) {
+	var conds []string
+	var args []any
+	if !from.IsZero() {
+		conds = append(conds, "posted_at >= ?")
+		args = append(args, from.Unix())
+	}
+	if !to.IsZero() {
+		conds = append(conds, "posted_at < ?")
+		args = append(args, to.Unix())
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+	return s.query("SELECT "+sqliteColumns+" FROM comments"+where+" ORDER BY rowid", args...)
+}
+
+func (s *SQLiteStore) QueryByUser(userID int, author string) ([]Record, error) {
+	if userID != 0 {
+		return s.query("SELECT "+sqliteColumns+" FROM comments WHERE user_id = ? ORDER BY rowid", userID)
+	}
+	return s.query("SELECT "+sqliteColumns+" FROM comments WHERE user_id = 0 AND author = ? ORDER BY rowid", author)
+}
+
+// LoadState returns the state saved under name, or an error wrapping
+// fs.ErrNotExist when there is none.
+func (s *SQLiteStore) LoadState(name string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow("SELECT data FROM state WHERE name = ?", name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("state %q: %w", name, fs.ErrNotExist)
+	}
+	return data, err
+}
+
+// SaveState replaces the state saved under name.
+func (s *SQLiteStore) SaveState(name string, data []byte) error {
+	_, err := s.db.Exec("INSERT INTO state (name, data) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET data = excluded.data", name, data)
+	return err
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) query(q string, args ...any) ([]Record, error) {
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var recs []Record
+	for rows.Next() {
+		var rec Record
+		var parsed string
+		if err := rows.Scan(&rec.ID, &rec.Author, &rec.UserID, &rec.DateGMT, &rec.VoteNegative, &rec.VotePositive, &rec.Content, &rec.Section, &rec.ParentID, &parsed); err != nil {
+			return nil, err
+		}
+		rec.Parsed, _ = decodeParsed(parsed)
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+// upgradeSQLite adds the columns in sqliteAddedColumns that db lacks.
+func upgradeSQLite(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('comments')")
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		have[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, c := range sqliteAddedColumns {
+		if have[c.name] {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE comments ADD COLUMN " + c.name + " " + c.def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execer is the part of *sql.DB and *sql.Tx that insertSQLite needs.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func insertSQLite(db execer, rec Record) (bool, error) {
+	var postedAt any
+	if t, err := ParseDate(rec.DateGMT); err == nil {
+		postedAt = t.Unix()
+	}
+	parsed, err := encodeParsed(rec.Parsed)
+	if err != nil {
+		return false, err
+	}
+	res, err := db.Exec(`INSERT INTO comments (`+sqliteColumns+`, posted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (section, id) DO NOTHING`,
+		rec.ID, rec.Author, rec.UserID, rec.DateGMT, rec.VoteNegative, rec.VotePositive, rec.Content, rec.Section, rec.ParentID, parsed, postedAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
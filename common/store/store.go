@@ -0,0 +1,215 @@
+// Package store persists crawled comments and replies. The crawler writes
+// through a Store and the analyzer reads through one, so neither depends on
+// how a backend lays out its data.
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"purify/common/content"
+)
+
+// DefaultSection is the section of rows written before sections existed.
+const DefaultSection = "pic"
+
+// Record is one stored comment or tucao reply.
+type Record struct {
+	ID           int    `json:"id"`
+	Author       string `json:"author"`
+	UserID       int    `json:"user_id"`
+	DateGMT      string `json:"date_gmt"`
+	VoteNegative int    `json:"vote_negative"`
+	VotePositive int    `json:"vote_positive"`
+	Content      string `json:"content"`
+	Section      string `json:"section"`
+	// ParentID is the comment a tucao reply belongs to, 0 for top-level comments.
+	ParentID int `json:"parent_id"`
+	// Parsed is the structure of Content. It is empty for records stored
+	// before comments were parsed.
+	Parsed content.Parsed `json:"parsed,omitzero"`
+}
+
+// Votes is the vote count of a stored record.
+type Votes struct {
+	Section      string
+	ID           int
+	VoteNegative int
+	VotePositive int
+}
+
+// Store is a backend for crawled records. Records are unique per section and ID.
+type Store interface {
+	// Has reports whether a record with id is stored in section.
+	Has(section string, id int) (bool, error)
+	// InsertIfAbsent stores rec unless its section and ID are already present
+	// and reports whether it was added.
+	InsertIfAbsent(rec Record) (bool, error)
+	// UpsertVotes overwrites the vote counts of the stored records with the
+	// given sections and IDs and returns how many changed. Unknown records are
+	// ignored.
+	UpsertVotes(votes []Votes) (int, error)
+	// QueryByTimeRange returns the records dated in [from, to). A zero bound is open.
+	QueryByTimeRange(from, to time.Time) ([]Record, error)
+	// QueryByUser returns the records of a user, matched by ID when userID is
+	// set and by author name otherwise.
+	QueryByUser(userID int, author string) ([]Record, error)
+	Close() error
+}
+
+// StateStore is implemented by backends that can also keep small named blobs
+// of crawler state, such as the crawl history.
+type StateStore interface {
+	// LoadState returns the state saved under name, or an error wrapping
+	// fs.ErrNotExist when there is none.
+	LoadState(name string) ([]byte, error)
+	SaveState(name string, data []byte) error
+}
+
+// BatchInserter is implemented by backends that store many records faster
+// together than one by one.
+type BatchInserter interface {
+	// InsertAll stores the records not already present and returns how many were added.
+	InsertAll(recs []Record) (int, error)
+}
+
+// Import stores the records of recs that dst lacks and returns how many were added.
+func Import(dst Store, recs []Record) (int, error) {
+	if b, ok := dst.(BatchInserter); ok {
+		return b.InsertAll(recs)
+	}
+	added := 0
+	for _, rec := range recs {
+		ok, err := dst.InsertIfAbsent(rec)
+		if err != nil {
+			return added, err
+		}
+		if ok {
+			added++
+		}
+	}
+	return added, nil
+}
+
+// Config selects a backend in config.json.
+type Config struct {
+	// Backend is "csv", "jsonl" or "sqlite".
+	Backend string `json:"backend"`
+	// Path is relative to the repository root unless absolute.
+	Path string `json:"path"`
+}
+
+// withDefaults fills in the backend and path config.json leaves out, resolving
+// a relative path against root.
+func (c Config) withDefaults(root string) Config {
+	if c.Backend == "" {
+		c.Backend = "csv"
+	}
+	if c.Path == "" {
+		c.Path = DefaultPath(c.Backend)
+	}
+	if !filepath.IsAbs(c.Path) {
+		c.Path = filepath.Join(root, c.Path)
+	}
+	return c
+}
+
+// Location returns the file the configured backend keeps its data in.
+func (c Config) Location(root string) string {
+	return c.withDefaults(root).Path
+}
+
+// Open opens the configured backend, resolving relative paths against root.
+func Open(cfg Config, root string) (Store, error) {
+	cfg = cfg.withDefaults(root)
+	path := cfg.Path
+	switch cfg.Backend {
+	case "csv":
+		return OpenCSV(path)
+	case "jsonl":
+		return OpenJSONL(path)
+	case "sqlite":
+		return OpenSQLite(path)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// DefaultPath is the file a backend uses when config.json names none.
+func DefaultPath(backend string) string {
+	if backend == "sqlite" {
+		return "user_activity.db"
+	}
+	return "user_activity." + backend
+}
+
+// ParseDate parses a date_gmt value such as "2025-12-11T10:14:36+08:00".
+func ParseDate(s string) (time.Time, error) {
+	layouts := []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date format: %s", s)
+}
+
+// inRange reports whether rec is dated in [from, to). Undated records only
+// match a fully open range.
+func inRange(rec Record, from, to time.Time) bool {
+	if from.IsZero() && to.IsZero() {
+		return true
+	}
+	t, err := ParseDate(rec.DateGMT)
+	if err != nil {
+		return false
+	}
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+// byUser reports whether rec belongs to the user.
+func byUser(rec Record, userID int, author string) bool {
+	if userID != 0 {
+		return rec.UserID == userID
+	}
+	return rec.UserID == 0 && rec.Author == author
+}
+
+type key struct {
+	section string
+	id      int
+}
+
+func keyOf(rec Record) key {
+	return key{section: rec.Section, id: rec.ID}
+}
+
+// votesByKey indexes votes by the record they belong to.
+func votesByKey(votes []Votes) map[key]Votes {
+	byKey := make(map[key]Votes, len(votes))
+	for _, v := range votes {
+		byKey[key{section: v.Section, id: v.ID}] = v
+	}
+	return byKey
+}
+
+// encodeParsed flattens p for backends that keep it in a single text column.
+func encodeParsed(p content.Parsed) (string, error) {
+	if p.IsZero() {
+		return "", nil
+	}
+	b, err := json.Marshal(p)
+	return string(b), err
+}
+
+// decodeParsed reverses encodeParsed.
+func decodeParsed(s string) (content.Parsed, error) {
+	var p content.Parsed
+	if s == "" {
+		return p, nil
+	}
+	err := json.Unmarshal([]byte(s), &p)
+	return p, err
+}
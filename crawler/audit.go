@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"purify/common/store"
+)
+
+// storedComment is the part of a stored top-level comment the gap audit needs.
+type storedComment struct {
+	ID   int
+	Date time.Time
+}
+
+// Gap is a stretch between two neighbouring stored comments of a section that
+// is longer than the audit threshold.
+type Gap struct {
+	Section string
+	Newer   storedComment
+	Older   storedComment
+}
+
+// loadStoredComments reads the top-level comments of every section from the
+// store, sorted by descending ID.
+func loadStoredComments(st store.Store) (map[string][]storedComment, error) {
+	records, err := st.QueryByTimeRange(time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	comments := make(map[string][]storedComment)
+	for _, rec := range records {
+		if rec.ParentID != 0 {
+			continue
+		}
+		t, err := parseDate(rec.DateGMT)
+		if err != nil {
+			continue
+		}
+		comments[rec.Section] = append(comments[rec.Section], storedComment{ID: rec.ID, Date: t})
+	}
+	for _, list := range comments {
+		sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	}
+	return comments, nil
+}
+
+// findGaps lists neighbouring comments that are further apart than maxGap.
+func findGaps(section string, comments []storedComment, maxGap time.Duration) []Gap {
+	var gaps []Gap
+	for i := 1; i < len(comments); i++ {
+		newer, older := comments[i-1], comments[i]
+		if newer.Date.Sub(older.Date) > maxGap {
+			gaps = append(gaps, Gap{Section: section, Newer: newer, Older: older})
+		}
+	}
+	return gaps
+}
+
+// runAudit reports suspicious gaps in the stored comments of every section and,
+// when backfill is set, re-fetches the pages covering each gap.
+func runAudit(st store.Store, sections []Section, maxGap time.Duration, backfill bool) {
+	comments, err := loadStoredComments(st)
+	if err != nil {
+		fmt.Println("failed to read stored comments:", err)
+		return
+	}
+	for _, sec := range sections {
+		gaps := findGaps(sec.Name, comments[sec.Name], maxGap)
+		fmt.Printf("[%s] %d stored comments, %d gaps longer than %s\n", sec.Name, len(comments[sec.Name]), len(gaps), maxGap)
+		for _, g := range gaps {
+			fmt.Printf("[%s] gap of %s between id=%d (%s) and id=%d (%s)\n", sec.Name, g.Newer.Date.Sub(g.Older.Date), g.Older.ID, g.Older.Date.Format(time.RFC3339), g.Newer.ID, g.Newer.Date.Format(time.RFC3339))
+		}
+		if !backfill || len(gaps) == 0 {
+			continue
+		}
+		for _, g := range gaps {
+			added, err := backfillGap(st, sec, g)
+			if err != nil {
+				fmt.Printf("[%s] backfill of gap below id=%d failed: %v\n", sec.Name, g.Newer.ID, err)
+				continue
+			}
+			fmt.Printf("[%s] backfilled %d items between id=%d and id=%d\n", sec.Name, added, g.Older.ID, g.Newer.ID)
+		}
+	}
+}
+
+// backfillGap finds the page holding the newer end of the gap and walks down
+// until the older end, appending only the items that are missing.
+func backfillGap(st store.Store, sec Section, g Gap) (int, error) {
+	first, err := fetchPage(sec, 0)
+	if err != nil {
+		return 0, err
+	}
+	if first.Data == nil {
+		return 0, fmt.Errorf("missing data block in first page response")
+	}
+	page, err := findPageAtOrBelow(sec, first.Data.CurrentPage, g.Newer.ID)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	done := make(chan struct{})
+	defer close(done)
+	for res := range prefetchPages(sec, page, done) {
+		if res.err != nil {
+			return added, res.err
+		}
+		resp := res.resp
+		fmt.Printf("[%s] Backfill page %d: items=%d\n", sec.Name, res.page, len(resp.Data.List))
+		added += appendNewItems(st, sec, resp)
+		if oldestID(resp.Data.List) <= g.Older.ID {
+			return added, nil
+		}
+	}
+	return added, nil
+}
+
+// findPageAtOrBelow binary searches pages 1..top for the newest page whose
+// oldest item is at or below id.
+func findPageAtOrBelow(sec Section, top, id int) (int, error) {
+	lo, hi := 1, top
+	found := 1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		resp, err := fetchPage(sec, mid)
+		if err != nil {
+			return 0, err
+		}
+		if resp.Data == nil || len(resp.Data.List) == 0 {
+			return 0, fmt.Errorf("no data for page %d", mid)
+		}
+		if oldestID(resp.Data.List) <= id {
+			found = mid
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+	return found, nil
+}
+
+// oldestID returns the smallest ID on a page.
+func oldestID(items []Item) int {
+	oldest := 0
+	for _, item := range items {
+		if oldest == 0 || item.ID < oldest {
+			oldest = item.ID
+		}
+	}
+	return oldest
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"purify/common/archive"
+	"purify/common/fetch"
+	"purify/common/store"
+)
+
+// defaultSection is the section that rows and history written before
+// sections existed belong to.
+const defaultSection = store.DefaultSection
+
+// Section identifies one Jandan comment section by its post ID.
+type Section struct {
+	Name   string `json:"name"`
+	PostID int    `json:"post_id"`
+}
+
+// Config represents the structure of config.json.
+type Config struct {
+	Sections []Section `json:"sections"`
+	// SnapshotDays is how long after posting the votes of a comment are snapshotted.
+	SnapshotDays int `json:"snapshot_days"`
+	// RateLimit bounds the adaptive request rate.
+	RateLimit fetch.LimiterConfig `json:"rate_limit"`
+	// Concurrency is the number of requests in flight at once.
+	Concurrency int `json:"concurrency"`
+	// Storage selects where crawled comments are kept.
+	Storage store.Config `json:"storage"`
+	// Archive optionally keeps local copies of crawled images.
+	Archive archive.Config `json:"archive"`
+}
+
+var defaultConfig = Config{
+	Sections:     []Section{{Name: defaultSection, PostID: 26402}},
+	SnapshotDays: 7,
+	Concurrency:  4,
+}
+
+// loadConfig reads config.json, falling back to the defaults when the file is missing.
+func loadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		cfg := defaultConfig
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Sections) == 0 {
+		cfg.Sections = defaultConfig.Sections
+	}
+	if cfg.SnapshotDays <= 0 {
+		cfg.SnapshotDays = defaultConfig.SnapshotDays
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConfig.Concurrency
+	}
+	seen := make(map[string]bool)
+	for _, s := range cfg.Sections {
+		if s.Name == "" || s.PostID <= 0 {
+			return nil, fmt.Errorf("invalid section %+v in %s", s, path)
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("duplicate section %q in %s", s.Name, path)
+		}
+		seen[s.Name] = true
+	}
+	return &cfg, nil
+}
@@ -1,216 +1,263 @@
 package main
 
 import (
-	"compress/gzip"
-	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"html"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 	"time"
+
+	"purify/common/archive"
+	"purify/common/content"
+	"purify/common/fetch"
+	"purify/common/phash"
+	"purify/common/store"
 )
 
-const baseURL = "https://jandan.net/api/comment/post/26402?order=desc&page=%d"
+const baseURL = "https://jandan.net/api/comment/post/%d?order=desc&page=%d"
+
+// tucaoURL lists the tucao (reply) thread under a single comment.
+const tucaoURL = "https://jandan.net/api/tucao/list/%d"
 
+// HistoryRecord is the crawl position of one section. NewestID and OldestID
+// bound the comments known to be stored without gaps. PendingNewestID is the
+// newest comment seen by a walk that has not reached NewestID yet; it only
+// becomes NewestID once that walk completes.
 type HistoryRecord struct {
-	LastExecution time.Time `json:"last_execution"`
-	LastPage      int       `json:"last_page"`
+	LastExecution   time.Time `json:"last_execution"`
+	NewestID        int       `json:"newest_id"`
+	OldestID        int       `json:"oldest_id"`
+	PendingNewestID int       `json:"pending_newest_id,omitempty"`
+}
+
+// History holds the HistoryRecord of every crawled section, keyed by section name.
+type History struct {
+	Sections map[string]*HistoryRecord `json:"sections"`
 }
 
 func main() {
+	mode := flag.String("mode", "crawl", "crawl, audit, backfill, refresh, votes, similar, migrate or rewrite")
+	maxGap := flag.Duration("max-gap", 3*time.Hour, "audit/backfill: report stretches without comments longer than this")
+	refreshDays := flag.Int("days", 0, "refresh: update vote counts of comments from the last N days and fetch their new replies (default snapshot_days)")
+	postID := flag.Int("id", 0, "votes: comment ID to print the vote curve of; similar: comment whose images to look up")
+	section := flag.String("section", defaultSection, "votes: section of the comment")
+	imageRef := flag.String("image", "", "similar: image URL or file to look up instead of a comment's images")
+	maxDistance := flag.Int("max-distance", 10, "similar: largest Hamming distance between hashes that counts as a near-duplicate")
+	migrateFrom := flag.String("from", "user_activity.csv", "migrate: CSV file to import into the configured storage")
+	flag.Parse()
+
 	wd, err := os.Getwd()
 	if err != nil {
 		panic(err)
 	}
 	parent := filepath.Dir(wd)
 	historyPath := filepath.Join(parent, "history.json")
-	userActivity := filepath.Join(parent, "user_activity.csv")
-
-	hist, _ := loadHistory(historyPath)
-	existingIDs := loadExistingIDs(userActivity)
-	fmt.Printf("Loaded %d existing IDs from CSV\n", len(existingIDs))
-
-	cutoff := time.Now().AddDate(0, -1, 0)
-	fmt.Printf("Cutoff (one month ago): %s\n", cutoff.Format(time.RFC3339))
+	voteSnapshots := filepath.Join(parent, "vote_snapshots.csv")
+	hashesPath := filepath.Join(parent, "image_hashes.csv")
 
-	if hist != nil {
-		runAscending(historyPath, userActivity, hist, existingIDs)
-	} else {
-		runDescending(historyPath, userActivity, cutoff, existingIDs)
+	cfg, err := loadConfig(filepath.Join(parent, "config.json"))
+	if err != nil {
+		panic(err)
 	}
-}
-
-// loadExistingIDs loads IDs from the CSV file into a map
-func loadExistingIDs(userActivity string) map[int]int {
-	existingIDs := make(map[int]int)
-	csvFile, err := os.Open(userActivity)
-	if err == nil {
-		reader := csv.NewReader(csvFile)
-		// Skip header
-		_, _ = reader.Read()
-		for {
-			rec, err := reader.Read()
-			if err == io.EOF {
-				break
-			}
-			if len(rec) > 0 {
-				id, err := strconv.Atoi(rec[0])
-				if err == nil {
-					existingIDs[id] = 1
-				}
-			}
-		}
-		csvFile.Close()
+	limiter = fetch.NewLimiter(cfg.RateLimit)
+	workers = cfg.Concurrency
+	if *mode == "votes" {
+		printVoteCurve(voteSnapshots, *section, *postID)
+		return
 	}
-	return existingIDs
-}
-
-// runAscending handles the ascending fetch logic
-func runAscending(historyPath, userActivity string, hist *HistoryRecord, existingIDs map[int]int) {
-	fmt.Println("Resuming from history...")
-	fmt.Printf("Last execution: %s, Last page: %d\n", hist.LastExecution.Format(time.RFC3339), hist.LastPage)
-	startPage := hist.LastPage
-	if startPage < 0 {
-		startPage = 0
-	}
-	initialPage := startPage
-	if initialPage < 0 {
-		initialPage = 0
-	}
-	url := fmt.Sprintf(baseURL, initialPage)
-	resp, err := fetchPage(url)
+	imageHashes, err = phash.OpenIndex(hashesPath)
 	if err != nil {
-		fmt.Printf("fetch error for page %d: %v\n", initialPage, err)
+		fmt.Println("failed to open image hashes:", err)
+	}
+	defer imageHashes.Close()
+	if *mode == "similar" {
+		printSimilar(*postID, *imageRef, *maxDistance)
 		return
 	}
-	time.Sleep(1000 * time.Millisecond)
-	totalPages := 0
-	if resp != nil && resp.Data != nil {
-		totalPages = resp.Data.TotalPages
+	if *mode == "rewrite" {
+		runRewrite(cfg.Storage, cfg.Storage.Location(parent))
+		return
 	}
-	w, f, err := openCSV(userActivity)
+	st, err := store.Open(cfg.Storage, parent)
 	if err != nil {
-		fmt.Println("failed to open csv:", err)
+		panic(err)
+	}
+	defer st.Close()
+	if cs, ok := st.(*store.CSVStore); ok && cs.Upgrade() != nil {
+		reportUpgrade(cfg.Storage.Location(parent), cs.Upgrade())
+	}
+	if *mode == "migrate" {
+		runMigrate(st, cfg.Storage.Location(parent), resolvePath(parent, *migrateFrom), historyPath)
 		return
 	}
-	defer f.Close()
+	hist, err := loadHistory(st, historyPath)
+	if err != nil {
+		hist = &History{Sections: make(map[string]*HistoryRecord)}
+	}
+
+	snapshots, err = openSnapshotLog(voteSnapshots, time.Duration(cfg.SnapshotDays)*24*time.Hour)
+	if err != nil {
+		fmt.Println("failed to open vote snapshots:", err)
+	}
+	defer snapshots.Close()
 
-	appendNewItems(w, resp, existingIDs)
+	archiver, err = archive.Open(cfg.Archive, parent)
+	if err != nil {
+		fmt.Println("failed to open media archive:", err)
+	}
+	defer archiver.Close()
 
-	for page := initialPage + 1; page <= totalPages; page++ {
-		url := fmt.Sprintf(baseURL, page)
-		resp, err := fetchPage(url)
-		if err != nil {
-			fmt.Printf("fetch error for page %d: %v\n", page, err)
-			break
-		}
-		if resp.Data == nil {
-			fmt.Printf("no data for page %d\n", page)
-			break
-		} else {
-			fmt.Printf("Page %d: items=%d (ascending)\n", page, len(resp.Data.List))
-			_ = saveHistory(historyPath, HistoryRecord{
-				LastExecution: time.Now(),
-				LastPage:      page,
-			})
-			appendNewItems(w, resp, existingIDs)
+	switch *mode {
+	case "crawl":
+	case "audit", "backfill":
+		runAudit(st, cfg.Sections, *maxGap, *mode == "backfill")
+		return
+	case "refresh":
+		if *refreshDays <= 0 {
+			*refreshDays = cfg.SnapshotDays
 		}
-		time.Sleep(1000 * time.Millisecond)
+		runRefresh(st, cfg.Sections, *refreshDays)
+		return
+	default:
+		fmt.Printf("unknown mode %q\n", *mode)
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, -1, 0)
+	fmt.Printf("Cutoff (one month ago): %s\n", cutoff.Format(time.RFC3339))
+
+	for _, sec := range cfg.Sections {
+		runSection(historyPath, st, sec, hist, cutoff)
 	}
-	fmt.Println("Stopping iteration due to page reached.")
-	appendNewItems(w, resp, existingIDs)
+	pruneArchive()
 }
 
-// runDescending handles the descending fetch logic
-func runDescending(historyPath, userActivity string, cutoff time.Time, existingIDs map[int]int) {
-	first, err := fetchPage(fmt.Sprintf(baseURL, 0))
-	if err != nil {
-		fmt.Println("failed to fetch first page:", err)
-		return
+// appendNewItems stores new items from resp if not already present
+func appendNewItems(st store.Store, sec Section, resp *RootResponse) int {
+	if resp != nil && resp.Data != nil {
+		return appendItems(st, sec, resp.Data.List)
 	}
-	if first.Data == nil {
-		fmt.Println("missing data block in first page response")
-		return
+	return 0
+}
+
+// appendItems stores the comments not already present, each followed by its
+// tucao replies, and returns how many records were added. Reply threads are
+// fetched concurrently but written in order.
+func appendItems(st store.Store, sec Section, items []Item) int {
+	var fresh []Item
+	seen := make(map[int]bool)
+	for _, item := range items {
+		found, err := st.Has(sec.Name, item.ID)
+		if err != nil {
+			fmt.Printf("[%s] failed to look up item %d: %v\n", sec.Name, item.ID, err)
+			continue
+		}
+		if !found && !seen[item.ID] {
+			fresh = append(fresh, item)
+			seen[item.ID] = true
+		}
 	}
-	w, f, err := openCSV(userActivity)
-	if err != nil {
-		fmt.Println("failed to open csv:", err)
-		return
+	if len(fresh) == 0 {
+		return 0
 	}
-	defer f.Close()
-	firstDescPage := first.Data.CurrentPage
-	_ = saveHistory(historyPath, HistoryRecord{
-		LastExecution: time.Now(),
-		LastPage:      firstDescPage,
-	})
-	for page := firstDescPage; page >= 0; page-- {
-		url := fmt.Sprintf(baseURL, page)
-		resp, err := fetchPage(url)
-		stop := false
+	replies := fetchAllReplies(sec, fresh)
+	added := 0
+	for _, item := range fresh {
+		fmt.Printf("[%s] Appending new item, ID: %d\n", sec.Name, item.ID)
+		rec := toRecord(sec, item)
+		ok, err := st.InsertIfAbsent(rec)
 		if err != nil {
-			fmt.Printf("fetch error for page %d: %v\n", page, err)
-			break
+			fmt.Printf("[%s] failed to store item %d: %v\n", sec.Name, item.ID, err)
+			continue
 		}
-		if resp.Data == nil {
-			fmt.Printf("no data for page %d\n", page)
-			break
-		} else {
-			fmt.Printf("Page %d: items=%d (descending)\n", page, len(resp.Data.List))
-			for _, item := range resp.Data.List {
-				if _, found := existingIDs[item.ID]; !found {
-					_ = appendCSVRecord(w, item)
-					w.Flush()
-					existingIDs[item.ID] = 1
-				}
-				t, perr := parseDate(item.DateGMT)
-				if perr != nil {
-					continue
-				}
-				if t.Before(cutoff) || t.Equal(cutoff) {
-					fmt.Printf("Reached cutoff at item id=%d date=%s (parsed=%s)\n", item.ID, item.DateGMT, t.Format(time.RFC3339))
-					stop = true
-					break
-				}
-			}
-			if stop {
-				fmt.Println("Stopping iteration due to cutoff reached.")
-				break
-			}
-			time.Sleep(1000 * time.Millisecond)
+		if ok {
+			added++
+			fetchImages(rec)
 		}
+		added += appendReplies(st, sec, replies[item.ID])
 	}
+	return added
 }
 
-// appendNewItems appends new items from resp to CSV if not already present
-func appendNewItems(w *csv.Writer, resp *RootResponse, existingIDs map[int]int) {
-	if resp != nil && resp.Data != nil {
-		for _, item := range resp.Data.List {
-			if _, found := existingIDs[item.ID]; !found {
-				fmt.Printf("Appending new item to CSV, ID: %d\n", item.ID)
-				_ = appendCSVRecord(w, item)
-				w.Flush()
-				existingIDs[item.ID] = 1
-			}
+// appendReplies stores the tucao replies not already present and returns how
+// many were added.
+func appendReplies(st store.Store, sec Section, replies []Item) int {
+	added := 0
+	for _, reply := range replies {
+		rec := toRecord(sec, reply)
+		ok, err := st.InsertIfAbsent(rec)
+		if err != nil {
+			fmt.Printf("[%s] failed to store reply %d: %v\n", sec.Name, reply.ID, err)
+		}
+		if ok {
+			added++
+			fetchImages(rec)
 		}
 	}
+	return added
+}
+
+// toRecord converts a crawled item of sec into a stored record.
+func toRecord(sec Section, item Item) store.Record {
+	return store.Record{
+		ID:           item.ID,
+		Author:       item.Author,
+		UserID:       item.UserId,
+		DateGMT:      item.DateGMT,
+		VoteNegative: item.VoteNegative,
+		VotePositive: item.VotePositive,
+		Content:      item.Content,
+		Section:      sec.Name,
+		ParentID:     item.ParentID,
+		Parsed:       content.Parse(item.Content),
+	}
+}
+
+func fetchPage(sec Section, page int) (*RootResponse, error) {
+	var tmp RootResponse
+	if err := fetchJSON(fmt.Sprintf(baseURL, sec.PostID, page), sec, &tmp); err != nil {
+		return nil, err
+	}
+	if tmp.Data != nil {
+		snapshots.record(sec.Name, tmp.Data.List)
+	}
+	return &tmp, nil
 }
 
-func fetchPage(url string) (*RootResponse, error) {
+// fetchReplies returns the tucao replies of a comment with ParentID set.
+func fetchReplies(sec Section, commentID int) ([]Item, error) {
+	var tmp TucaoResponse
+	if err := fetchJSON(fmt.Sprintf(tucaoURL, commentID), sec, &tmp); err != nil {
+		return nil, err
+	}
+	if tmp.Data == nil {
+		return nil, nil
+	}
+	replies := tmp.Data.List
+	for i := range replies {
+		replies[i].ParentID = commentID
+	}
+	snapshots.record(sec.Name, replies)
+	return replies, nil
+}
+
+// fetchOnce makes a single request and decodes the JSON body into v. Failures
+// worth retrying are returned as *fetchError.
+func fetchOnce(url string, sec Section, v any) error {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// Add requested headers
 	req.Header.Set("Accept", "application/json, text/plain, */*")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
-	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
+	req.Header.Set("Accept-Encoding", fetch.AcceptEncoding)
 	req.Header.Set("Priority", "u=1, i")
-	req.Header.Set("Referer", "https://jandan.net/pic")
+	req.Header.Set("Referer", "https://jandan.net/"+sec.Name)
 	req.Header.Set("Sec-CH-UA", "\"Chromium\";v=\"142\", \"Google Chrome\";v=\"142\", \"Not_A Brand\";v=\"99\"")
 	req.Header.Set("Sec-CH-UA-Mobile", "?0")
 	req.Header.Set("Sec-CH-UA-Platform", "\"macOS\"")
@@ -221,40 +268,22 @@ func fetchPage(url string) (*RootResponse, error) {
 	// Set Host (authority) explicitly
 	req.Host = "jandan.net"
 
-	client := &http.Client{}
-	r, err := client.Do(req)
+	r, err := limiter.Do(httpClient, req)
 	if err != nil {
-		return nil, err
+		return &fetchError{Retryable: true, Err: err}
 	}
 	defer r.Body.Close()
 	if r.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d", r.StatusCode)
+		return statusError(r)
 	}
-	var b []byte
-	if enc := r.Header.Get("Content-Encoding"); enc == "gzip" {
-		gr, gerr := gzip.NewReader(r.Body)
-		if gerr != nil {
-			return nil, gerr
-		}
-		defer gr.Close()
-		data, rerr := io.ReadAll(gr)
-		if rerr != nil {
-			return nil, rerr
-		}
-		b = data
-	} else {
-		data, rerr := io.ReadAll(r.Body)
-		if rerr != nil {
-			return nil, rerr
-		}
-		b = data
+	b, err := fetch.ReadBody(r)
+	if errors.Is(err, fetch.ErrUnsupportedEncoding) {
+		return err
 	}
-	// Optional: quick sanity check
-	var tmp RootResponse
-	if err := json.Unmarshal(b, &tmp); err != nil {
-		return nil, err
+	if err != nil {
+		return &fetchError{Retryable: true, Err: err}
 	}
-	return &tmp, nil
+	return json.Unmarshal(b, v)
 }
 
 // parseDate handles the date_gmt format like "2025-12-11T10:14:36+08:00"
@@ -263,7 +292,25 @@ func parseDate(s string) (time.Time, error) {
 	return time.Parse(time.RFC3339, s)
 }
 
-func loadHistory(path string) (*HistoryRecord, error) {
+// historyState is the name the crawl history is saved under in a store that
+// keeps state; other stores leave it in history.json.
+const historyState = "history"
+
+// loadHistory reads the crawl history from st when it keeps state and from
+// history.json otherwise.
+func loadHistory(st store.Store, path string) (*History, error) {
+	if ss, ok := st.(store.StateStore); ok {
+		b, err := ss.LoadState(historyState)
+		if err != nil {
+			return nil, err
+		}
+		return decodeHistory(b)
+	}
+	return readHistoryFile(path)
+}
+
+// readHistoryFile reads history.json.
+func readHistoryFile(path string) (*History, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -273,60 +320,40 @@ func loadHistory(path string) (*HistoryRecord, error) {
 	if err != nil {
 		return nil, err
 	}
-	var h HistoryRecord
+	return decodeHistory(b)
+}
+
+// decodeHistory parses a saved history. One written before sections existed
+// holds a single top-level record, which becomes the default section's.
+func decodeHistory(b []byte) (*History, error) {
+	var h struct {
+		History
+		HistoryRecord
+	}
 	if err := json.Unmarshal(b, &h); err != nil {
 		return nil, err
 	}
-	return &h, nil
+	if h.Sections == nil {
+		h.Sections = make(map[string]*HistoryRecord)
+	}
+	if _, ok := h.Sections[defaultSection]; !ok && !h.LastExecution.IsZero() {
+		legacy := h.HistoryRecord
+		h.Sections[defaultSection] = &legacy
+	}
+	return &h.History, nil
 }
 
-func saveHistory(path string, h HistoryRecord) error {
+func saveHistory(st store.Store, path string, h *History) error {
 	b, err := json.MarshalIndent(h, "", "  ")
 	if err != nil {
 		return err
 	}
+	if ss, ok := st.(store.StateStore); ok {
+		return ss.SaveState(historyState, b)
+	}
 	// Ensure directory exists
 	if err := os.MkdirAll("data", 0o755); err != nil {
 		return err
 	}
 	return os.WriteFile(path, b, 0o644)
 }
-
-// openCSV opens/creates a CSV file in append mode and writes header if empty
-func openCSV(path string) (*csv.Writer, *os.File, error) {
-	if err := os.MkdirAll("data", 0o755); err != nil {
-		return nil, nil, err
-	}
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
-	if err != nil {
-		return nil, nil, err
-	}
-	info, _ := f.Stat()
-	w := csv.NewWriter(f)
-	if info.Size() == 0 {
-		_ = w.Write([]string{"id", "author", "user_id", "date_gmt", "vote_negative", "vote_positive", "content"})
-		w.Flush()
-	}
-	return w, f, nil
-}
-
-func appendCSVRecord(w *csv.Writer, item Item) error {
-	rec := []string{
-		fmt.Sprintf("%d", item.ID),
-		item.Author,
-		fmt.Sprintf("%d", item.UserId),
-		item.DateGMT,
-		fmt.Sprintf("%d", item.VoteNegative),
-		fmt.Sprintf("%d", item.VotePositive),
-		encodeLineBreaks(item.Content),
-	}
-	if err := w.Write(rec); err != nil {
-		return err
-	}
-	return nil
-}
-
-func encodeLineBreaks(s string) string {
-	s = strings.ReplaceAll(s, "\n", "\\n")
-	return html.EscapeString(s)
-}
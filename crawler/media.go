@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"purify/common/archive"
+	"purify/common/fetch"
+	"purify/common/phash"
+	"purify/common/store"
+)
+
+// archiver keeps copies of the images of newly stored comments. It stays nil,
+// and archiving a no-op, unless config.json enables the archive.
+var archiver *archive.Archive
+
+// imageHashes indexes the perceptual hash of every image the crawler downloads.
+var imageHashes *phash.Index
+
+// fetchImages downloads the images rec shows, preferring the original size and
+// falling back to the large one. Every image is hashed into imageHashes, so
+// near-duplicate queries cover the whole crawl, and kept in the archive when
+// it is enabled. Images another comment already archived are read from the
+// archive instead of downloaded again.
+func fetchImages(rec store.Record) {
+	for _, img := range rec.Parsed.Images {
+		for _, url := range []string{img.Original, img.Large} {
+			data, ok := archiver.Get(url)
+			if !ok {
+				var err error
+				data, err = fetch.DownloadImage(limiter, url)
+				if err != nil {
+					fmt.Printf("[%s] failed to download image of %d: %v\n", rec.Section, rec.ID, err)
+					continue
+				}
+			}
+			if _, err := archiver.Put(rec.Section, rec.ID, url, data); err != nil {
+				fmt.Printf("[%s] failed to archive image of %d: %v\n", rec.Section, rec.ID, err)
+			}
+			indexImage(rec, url, data)
+			break
+		}
+	}
+}
+
+// indexImage records the perceptual hash of an image rec shows. Images in
+// formats the hasher cannot decode are skipped.
+func indexImage(rec store.Record, url string, data []byte) {
+	h, err := phash.Compute(data)
+	if err != nil {
+		return
+	}
+	if err := imageHashes.Add(phash.Entry{Section: rec.Section, PostID: rec.ID, URL: url, Hash: h}); err != nil {
+		fmt.Printf("[%s] failed to index image of %d: %v\n", rec.Section, rec.ID, err)
+	}
+}
+
+// pruneArchive holds the archive to its size and retention budget.
+func pruneArchive() {
+	removed, freed, err := archiver.Prune(time.Now())
+	if err != nil {
+		fmt.Println("failed to prune media archive:", err)
+	}
+	if removed > 0 {
+		fmt.Printf("Pruned %d archived images (%d bytes)\n", removed, freed)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"purify/common/store"
+)
+
+// runMigrate imports the comments of the CSV file at from into st and, when st
+// keeps state, the crawl history from history.json. It can be re-run safely:
+// records already in st are skipped.
+func runMigrate(st store.Store, location, from, historyPath string) {
+	if location == from {
+		fmt.Printf("%s is already the configured storage, nothing to migrate\n", from)
+		return
+	}
+	if _, err := os.Stat(from); err != nil {
+		fmt.Println("failed to open source csv:", err)
+		return
+	}
+	src, err := store.OpenCSV(from)
+	if err != nil {
+		fmt.Println("failed to open source csv:", err)
+		return
+	}
+	recs, err := src.QueryByTimeRange(time.Time{}, time.Time{})
+	src.Close()
+	if err != nil {
+		fmt.Println("failed to read source csv:", err)
+		return
+	}
+	added, err := store.Import(st, recs)
+	if err != nil {
+		fmt.Println("failed to import records:", err)
+		return
+	}
+	fmt.Printf("Imported %d of %d records from %s into %s\n", added, len(recs), from, location)
+
+	if _, ok := st.(store.StateStore); !ok {
+		return
+	}
+	hist, err := readHistoryFile(historyPath)
+	if err != nil {
+		fmt.Println("no history to import:", err)
+		return
+	}
+	if err := saveHistory(st, historyPath, hist); err != nil {
+		fmt.Println("failed to import history:", err)
+		return
+	}
+	fmt.Printf("Imported crawl history of %d sections\n", len(hist.Sections))
+}
+
+// runRewrite upgrades the configured CSV store to the current columns and
+// lossless content encoding without crawling. Opening a CSV store does the
+// same, so this only saves waiting for the next crawl.
+func runRewrite(cfg store.Config, location string) {
+	if cfg.Backend != "" && cfg.Backend != "csv" {
+		fmt.Printf("%s is not a CSV store, nothing to rewrite\n", location)
+		return
+	}
+	upgrade, err := store.UpgradeCSV(location)
+	if err != nil {
+		fmt.Println("failed to rewrite:", err)
+		return
+	}
+	if upgrade == nil {
+		fmt.Printf("%s already uses the current encoding\n", location)
+		return
+	}
+	reportUpgrade(location, upgrade)
+}
+
+// reportUpgrade logs how a CSV store was upgraded.
+func reportUpgrade(location string, u *store.CSVUpgrade) {
+	fmt.Printf("Upgraded %s to columns %v\n", location, u.Columns)
+	if u.ReencodedFrom != "" {
+		fmt.Printf("Re-encoded the %s column of %s losslessly\n", u.ReencodedFrom, location)
+	}
+}
+
+// resolvePath resolves a path given on the command line against the repository root.
+func resolvePath(root, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
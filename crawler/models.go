@@ -26,6 +26,22 @@ type Item struct {
 	Content      string `json:"content"`
 	VoteNegative int    `json:"vote_negative"`
 	VotePositive int    `json:"vote_positive"`
+	// ReplyCount is the number of tucao replies a comment has.
+	ReplyCount int `json:"sub_comment_count"`
+	// ParentID is the comment a tucao reply belongs to, 0 for top-level comments.
+	ParentID int `json:"-"`
+}
+
+// TucaoResponse represents the JSON returned for a comment's tucao thread.
+type TucaoResponse struct {
+	Code int         `json:"code"`
+	Msg  string      `json:"msg"`
+	Data *TucaoBlock `json:"data"`
+}
+
+// TucaoBlock represents the "data" object of a tucao thread.
+type TucaoBlock struct {
+	List []Item `json:"list"`
 }
 
 // DecodeRootResponse unmarshals bytes into RootResponse.
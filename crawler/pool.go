@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// workers bounds how many requests the crawler has in flight at once. main
+// sets it from config.json; all of them still go through the shared limiter.
+var workers = 1
+
+// pageResult is the outcome of fetching one page.
+type pageResult struct {
+	page int
+	resp *RootResponse
+	err  error
+}
+
+// prefetchPages fetches the pages from down to 0 with up to workers requests
+// in flight and delivers the results strictly in that order, so the caller can
+// write and checkpoint them sequentially. Closing done stops it early.
+func prefetchPages(sec Section, from int, done <-chan struct{}) <-chan pageResult {
+	order := make(chan chan pageResult, workers)
+	out := make(chan pageResult)
+	go func() {
+		defer close(order)
+		sem := make(chan struct{}, workers)
+		for page := from; page >= 0; page-- {
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+			ch := make(chan pageResult, 1)
+			go func(page int) {
+				defer func() { <-sem }()
+				resp, err := fetchPage(sec, page)
+				if err == nil && resp.Data == nil {
+					err = fmt.Errorf("no data for page %d", page)
+				}
+				ch <- pageResult{page: page, resp: resp, err: err}
+			}(page)
+			select {
+			case order <- ch:
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(out)
+		for ch := range order {
+			// Waiting for the page and handing it on are separate selects, so
+			// closing done also interrupts a slow fetch.
+			var res pageResult
+			select {
+			case res = <-ch:
+			case <-done:
+				return
+			}
+			select {
+			case out <- res:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// fetchAllReplies fetches the tucao threads of items with up to workers
+// requests in flight, keyed by comment ID. Failed threads are logged and left out.
+func fetchAllReplies(sec Section, items []Item) map[int][]Item {
+	replies := make(map[int][]Item, len(items))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for _, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			list, err := fetchReplies(sec, id)
+			if err != nil {
+				fmt.Printf("[%s] fetch error for replies of %d: %v\n", sec.Name, id, err)
+				return
+			}
+			mu.Lock()
+			replies[id] = list
+			mu.Unlock()
+		}(item.ID)
+	}
+	wg.Wait()
+	return replies
+}
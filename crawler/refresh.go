@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"purify/common/store"
+)
+
+// runRefresh re-crawls the comments of the last days in every section and
+// updates their stored vote counts in place, so analysis sees settled votes.
+// The crawl captures a reply thread once, right after the comment appeared, so
+// threads whose reply count no longer matches the replies stored are fetched
+// again for the replies posted since and the current votes of all replies.
+func runRefresh(st store.Store, sections []Section, days int) {
+	since := time.Now().AddDate(0, 0, -days)
+	fmt.Printf("Refreshing votes of comments since %s\n", since.Format(time.RFC3339))
+	stored, err := storedReplies(st, since)
+	if err != nil {
+		fmt.Println("failed to count stored replies:", err)
+		return
+	}
+	var updates []store.Votes
+	for _, sec := range sections {
+		votes := make(map[int]Item)
+		n, err := collectVotes(sec, since, votes)
+		if err != nil {
+			fmt.Printf("[%s] refresh stopped early: %v\n", sec.Name, err)
+		}
+		fmt.Printf("[%s] Collected votes of %d comments\n", sec.Name, n)
+		var comments, changed []Item
+		for _, item := range votes {
+			comments = append(comments, item)
+			if item.ReplyCount != stored[commentKey{sec.Name, item.ID}] {
+				changed = append(changed, item)
+			}
+		}
+		fmt.Printf("[%s] Fetching %d reply threads whose reply count changed\n", sec.Name, len(changed))
+		replies := fetchAllReplies(sec, sortNewestFirst(changed))
+		added := 0
+		for _, item := range sortNewestFirst(comments) {
+			added += appendReplies(st, sec, replies[item.ID])
+			updates = append(updates, votesOf(sec, item))
+			for _, reply := range replies[item.ID] {
+				updates = append(updates, votesOf(sec, reply))
+			}
+		}
+		fmt.Printf("[%s] Stored %d new replies\n", sec.Name, added)
+	}
+	updated, err := st.UpsertVotes(updates)
+	if err != nil {
+		fmt.Println("failed to update votes:", err)
+		return
+	}
+	fmt.Printf("Updated vote counts of %d stored comments and replies\n", updated)
+}
+
+// storedReplies counts the stored replies of every thread with replies dated
+// since. Replies are never older than their comment, so this covers every
+// comment of the refresh window.
+func storedReplies(st store.Store, since time.Time) (map[commentKey]int, error) {
+	recs, err := st.QueryByTimeRange(since, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[commentKey]int)
+	for _, rec := range recs {
+		if rec.ParentID != 0 {
+			counts[commentKey{rec.Section, rec.ParentID}]++
+		}
+	}
+	return counts, nil
+}
+
+// collectVotes walks a section from its newest page until it passes since,
+// recording the current votes of every comment it sees.
+func collectVotes(sec Section, since time.Time, votes map[int]Item) (int, error) {
+	first, err := fetchPage(sec, 0)
+	if err != nil {
+		return 0, err
+	}
+	if first.Data == nil {
+		return 0, fmt.Errorf("missing data block in first page response")
+	}
+	n := 0
+	done := make(chan struct{})
+	defer close(done)
+	for res := range prefetchPages(sec, first.Data.CurrentPage, done) {
+		if res.err != nil {
+			return n, res.err
+		}
+		fmt.Printf("[%s] Refresh page %d: items=%d\n", sec.Name, res.page, len(res.resp.Data.List))
+		passed := false
+		for _, item := range res.resp.Data.List {
+			t, perr := parseDate(item.DateGMT)
+			if perr == nil && t.Before(since) {
+				passed = true
+				continue
+			}
+			if _, ok := votes[item.ID]; !ok {
+				n++
+			}
+			votes[item.ID] = item
+		}
+		if passed {
+			return n, nil
+		}
+	}
+	return n, nil
+}
+
+// votesOf returns the current vote count of a crawled item of sec.
+func votesOf(sec Section, item Item) store.Votes {
+	return store.Votes{Section: sec.Name, ID: item.ID, VoteNegative: item.VoteNegative, VotePositive: item.VotePositive}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"purify/common/fetch"
+)
+
+const (
+	// requestTimeout bounds a single request including reading the body.
+	requestTimeout = 30 * time.Second
+	// maxAttempts is how often a request is tried before giving up.
+	maxAttempts = 5
+	baseBackoff = 2 * time.Second
+	maxBackoff  = 2 * time.Minute
+)
+
+var httpClient = &http.Client{Timeout: requestTimeout}
+
+// limiter paces every request made through fetchOnce. main sets it from config.json.
+var limiter *fetch.Limiter
+
+// fetchError is a failed request, classified as retryable or fatal.
+type fetchError struct {
+	Status     int
+	RetryAfter time.Duration
+	Retryable  bool
+	Err        error
+}
+
+func (e *fetchError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("HTTP %d", e.Status)
+}
+
+func (e *fetchError) Unwrap() error {
+	return e.Err
+}
+
+// statusError classifies a non-200 response. Rate limiting, timeouts and
+// server errors are retryable, other client errors are fatal.
+func statusError(r *http.Response) *fetchError {
+	e := &fetchError{Status: r.StatusCode}
+	switch {
+	case r.StatusCode == http.StatusTooManyRequests, r.StatusCode == http.StatusServiceUnavailable:
+		e.Retryable = true
+		e.RetryAfter = parseRetryAfter(r.Header.Get("Retry-After"))
+	case r.StatusCode == http.StatusRequestTimeout, r.StatusCode >= 500:
+		e.Retryable = true
+	}
+	return e
+}
+
+// parseRetryAfter accepts both forms of the Retry-After header, delay in
+// seconds or an HTTP date, and returns 0 when it is absent or malformed.
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// backoff returns the jittered exponential delay before the given retry.
+func backoff(attempt int) time.Duration {
+	d := baseBackoff << (attempt - 1)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// fetchJSON fetches url and decodes the JSON body into v, retrying retryable
+// failures with backoff and honouring Retry-After.
+func fetchJSON(url string, sec Section, v any) error {
+	for attempt := 1; ; attempt++ {
+		err := fetchOnce(url, sec, v)
+		if err == nil {
+			return nil
+		}
+		var fe *fetchError
+		if !errors.As(err, &fe) || !fe.Retryable {
+			return err
+		}
+		if attempt == maxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		wait := backoff(attempt)
+		if fe.RetryAfter > wait {
+			wait = min(fe.RetryAfter, maxBackoff)
+		}
+		fmt.Printf("[%s] %s failed (%v), retrying in %s (attempt %d/%d)\n", sec.Name, url, err, wait.Round(time.Millisecond), attempt+1, maxAttempts)
+		time.Sleep(wait)
+	}
+}
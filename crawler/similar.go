@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"purify/common/fetch"
+	"purify/common/phash"
+)
+
+// printSimilar lists the indexed images that are near-duplicates of the image
+// at ref, a URL or file, or of every indexed image of comment id.
+func printSimilar(id int, ref string, maxDistance int) {
+	var queries []phash.Entry
+	if ref != "" {
+		h, err := hashImage(ref)
+		if err != nil {
+			fmt.Println("failed to hash image:", err)
+			return
+		}
+		queries = append(queries, phash.Entry{URL: ref, Hash: h})
+	} else {
+		queries = imageHashes.ByPost(id)
+		if len(queries) == 0 {
+			fmt.Printf("No hashed images for id=%d\n", id)
+			return
+		}
+	}
+	for _, q := range queries {
+		fmt.Printf("Near-duplicates of %s (%s):\n", q.URL, q.Hash)
+		found := 0
+		for _, m := range imageHashes.Near(q.Hash, maxDistance) {
+			if m.PostID == q.PostID && m.Section == q.Section && m.URL == q.URL {
+				continue
+			}
+			fmt.Printf("  [%s] id=%d distance=%d seen %s %s\n", m.Section, m.PostID, m.Distance, m.SeenAt.Format("2006-01-02"), m.URL)
+			found++
+		}
+		if found == 0 {
+			fmt.Println("  none")
+		}
+	}
+}
+
+// hashImage hashes the image at ref, downloading it when ref is a URL.
+func hashImage(ref string) (phash.Hash, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		data, err = fetch.DownloadImage(limiter, ref)
+	} else {
+		data, err = os.ReadFile(ref)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return phash.Compute(data)
+}
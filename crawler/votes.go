@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// VoteSnapshot is the vote count of a comment at the time the crawler saw it.
+type VoteSnapshot struct {
+	Section      string
+	ID           int
+	SeenAt       time.Time
+	VotePositive int
+	VoteNegative int
+}
+
+// The section column came last; snapshots recorded before it have an empty
+// section and match a comment of that ID in any section.
+var voteSnapshotHeader = []string{"id", "seen_at", "vote_positive", "vote_negative", "section"}
+
+// snapshots records the votes of every item fetchPage and fetchReplies return.
+// It stays nil, and recording a no-op, unless main opens a snapshot log.
+var snapshots *snapshotLog
+
+// snapshotLog appends vote snapshots of comments younger than window to a CSV file.
+type snapshotLog struct {
+	mu     sync.Mutex
+	w      *csv.Writer
+	f      *os.File
+	window time.Duration
+	last   map[commentKey][2]int
+}
+
+// commentKey identifies a comment or reply across sections.
+type commentKey struct {
+	section string
+	id      int
+}
+
+// openSnapshotLog opens/creates the snapshot CSV file in append mode, adding
+// the section column to files written without it.
+func openSnapshotLog(path string, window time.Duration) (*snapshotLog, error) {
+	if err := upgradeSnapshots(path); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, _ := f.Stat()
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		_ = w.Write(voteSnapshotHeader)
+		w.Flush()
+	}
+	return &snapshotLog{w: w, f: f, window: window, last: make(map[commentKey][2]int)}, nil
+}
+
+// upgradeSnapshots rewrites a snapshot file that lacks the section column.
+func upgradeSnapshots(path string) error {
+	in, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rows, err := csv.NewReader(in).ReadAll()
+	in.Close()
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 || len(rows[0]) >= len(voteSnapshotHeader) {
+		return nil
+	}
+	rows[0] = voteSnapshotHeader
+	for i := 1; i < len(rows); i++ {
+		rows[i] = append(rows[i], "")
+	}
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(out)
+	if err := w.WriteAll(rows); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	fmt.Printf("Added a section column to %s\n", path)
+	return nil
+}
+
+// record appends a snapshot for every item of section still inside the
+// window. Sightings within one run that repeat the previous counts are skipped.
+func (l *snapshotLog) record(section string, items []Item) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now().UTC()
+	for _, item := range items {
+		t, err := parseDate(item.DateGMT)
+		if err != nil || now.Sub(t) > l.window {
+			continue
+		}
+		counts := [2]int{item.VotePositive, item.VoteNegative}
+		key := commentKey{section, item.ID}
+		if prev, ok := l.last[key]; ok && prev == counts {
+			continue
+		}
+		l.last[key] = counts
+		_ = l.w.Write([]string{
+			strconv.Itoa(item.ID),
+			now.Format(time.RFC3339),
+			strconv.Itoa(item.VotePositive),
+			strconv.Itoa(item.VoteNegative),
+			section,
+		})
+	}
+	l.w.Flush()
+}
+
+func (l *snapshotLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.w.Flush()
+	return l.f.Close()
+}
+
+// loadVoteCurve returns the snapshots recorded for a comment of section,
+// oldest first.
+func loadVoteCurve(path, section string, id int) ([]VoteSnapshot, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	// Skip header
+	_, _ = reader.Read()
+	want := strconv.Itoa(id)
+	var curve []VoteSnapshot
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rec[0] != want || len(rec) > 4 && rec[4] != "" && rec[4] != section {
+			continue
+		}
+		seenAt, err := time.Parse(time.RFC3339, rec[1])
+		if err != nil {
+			continue
+		}
+		pos, _ := strconv.Atoi(rec[2])
+		neg, _ := strconv.Atoi(rec[3])
+		curve = append(curve, VoteSnapshot{Section: section, ID: id, SeenAt: seenAt, VotePositive: pos, VoteNegative: neg})
+	}
+	sort.Slice(curve, func(i, j int) bool { return curve[i].SeenAt.Before(curve[j].SeenAt) })
+	return curve, nil
+}
+
+// printVoteCurve prints the vote curve of a comment of section relative to its
+// first snapshot.
+func printVoteCurve(path, section string, id int) {
+	curve, err := loadVoteCurve(path, section, id)
+	if err != nil {
+		fmt.Println("failed to read vote snapshots:", err)
+		return
+	}
+	if len(curve) == 0 {
+		fmt.Printf("No vote snapshots for [%s] id=%d\n", section, id)
+		return
+	}
+	fmt.Printf("Vote curve of [%s] id=%d (%d snapshots):\n", section, id, len(curve))
+	for _, s := range curve {
+		fmt.Printf("  %s (+%s)  OO=%d XX=%d\n", s.SeenAt.Format(time.RFC3339), s.SeenAt.Sub(curve[0].SeenAt).Round(time.Minute), s.VotePositive, s.VoteNegative)
+	}
+}
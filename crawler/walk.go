@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"purify/common/store"
+)
+
+// maxRefetch bounds how often a pair of pages is re-fetched after the
+// boundary between them moved.
+const maxRefetch = 3
+
+// runSection walks a section from its newest page towards older ones. The walk
+// stops at the first comment at or below the NewestID cursor, or at the cutoff
+// when the section has no cursor yet. The cursor only advances once a walk has
+// completed, so an interrupted run is simply walked again on the next one.
+func runSection(historyPath string, st store.Store, sec Section, hist *History, cutoff time.Time) {
+	rec := hist.Sections[sec.Name]
+	if rec == nil {
+		rec = &HistoryRecord{}
+		hist.Sections[sec.Name] = rec
+	}
+	if rec.NewestID > 0 {
+		fmt.Printf("[%s] Resuming from history...\n", sec.Name)
+		fmt.Printf("[%s] Last execution: %s, newest id: %d, oldest id: %d\n", sec.Name, rec.LastExecution.Format(time.RFC3339), rec.NewestID, rec.OldestID)
+	} else {
+		fmt.Printf("[%s] No cursor in history, crawling back to cutoff\n", sec.Name)
+	}
+
+	first, err := fetchPage(sec, 0)
+	if err != nil {
+		fmt.Printf("[%s] failed to fetch first page: %v\n", sec.Name, err)
+		return
+	}
+	if first.Data == nil {
+		fmt.Printf("[%s] missing data block in first page response\n", sec.Name)
+		return
+	}
+
+	var prev []Item
+	prevTotal := 0
+	walkNewest, walkOldest := 0, 0
+	// The walk is complete when it stops at the cursor or cutoff or runs out of pages.
+	complete := true
+	done := make(chan struct{})
+	defer close(done)
+	for res := range prefetchPages(sec, first.Data.CurrentPage, done) {
+		page, resp := res.page, res.resp
+		if res.err != nil {
+			fmt.Printf("[%s] fetch error for page %d: %v\n", sec.Name, page, res.err)
+			complete = false
+			break
+		}
+		if prev != nil && resp.Data.Total != prevTotal && !overlaps(prev, resp.Data.List) {
+			fmt.Printf("[%s] Page boundary moved (total %d -> %d), re-fetching pages %d and %d\n", sec.Name, prevTotal, resp.Data.Total, page+1, page)
+			resp, err = refetchBoundary(st, sec, page)
+			if err != nil {
+				fmt.Printf("[%s] fetch error while re-fetching page %d: %v\n", sec.Name, page, err)
+				complete = false
+				break
+			}
+		}
+
+		items := sortNewestFirst(resp.Data.List)
+		fmt.Printf("[%s] Page %d: items=%d\n", sec.Name, page, len(items))
+		// Only the first page bounds what this walk covers. Re-fetched pages
+		// can carry comments posted since, with unfetched ones between them.
+		if prev == nil && len(items) > 0 {
+			walkNewest = items[0].ID
+		}
+		stop := false
+		var batch []Item
+		for _, item := range items {
+			if rec.NewestID > 0 && item.ID <= rec.NewestID {
+				fmt.Printf("[%s] Reached cursor at item id=%d\n", sec.Name, item.ID)
+				stop = true
+				break
+			}
+			if walkOldest == 0 || item.ID < walkOldest {
+				walkOldest = item.ID
+			}
+			batch = append(batch, item)
+			if rec.NewestID > 0 {
+				continue
+			}
+			t, perr := parseDate(item.DateGMT)
+			if perr != nil {
+				continue
+			}
+			if t.Before(cutoff) || t.Equal(cutoff) {
+				fmt.Printf("[%s] Reached cutoff at item id=%d date=%s (parsed=%s)\n", sec.Name, item.ID, item.DateGMT, t.Format(time.RFC3339))
+				stop = true
+				break
+			}
+		}
+		appendItems(st, sec, batch)
+
+		rec.LastExecution = time.Now()
+		if walkNewest > rec.PendingNewestID {
+			rec.PendingNewestID = walkNewest
+		}
+		_ = saveHistory(st, historyPath, hist)
+		if stop {
+			break
+		}
+		prev, prevTotal = items, resp.Data.Total
+	}
+	if !complete {
+		fmt.Printf("[%s] Walk interrupted, cursor stays at %d\n", sec.Name, rec.NewestID)
+		return
+	}
+
+	if rec.PendingNewestID > rec.NewestID {
+		rec.NewestID = rec.PendingNewestID
+	}
+	rec.PendingNewestID = 0
+	if walkOldest > 0 && (rec.OldestID == 0 || walkOldest < rec.OldestID) {
+		rec.OldestID = walkOldest
+	}
+	rec.LastExecution = time.Now()
+	_ = saveHistory(st, historyPath, hist)
+	fmt.Printf("[%s] Walk complete, cursor now newest id=%d, oldest id=%d\n", sec.Name, rec.NewestID, rec.OldestID)
+}
+
+// refetchBoundary re-fetches the page above page together with page itself
+// until both agree on the total or overlap, appending anything new found on
+// the upper one. It returns the last fetch of page.
+func refetchBoundary(st store.Store, sec Section, page int) (*RootResponse, error) {
+	var lower *RootResponse
+	for attempt := 1; attempt <= maxRefetch; attempt++ {
+		upper, err := fetchPage(sec, page+1)
+		if err != nil {
+			return nil, err
+		}
+		lower, err = fetchPage(sec, page)
+		if err != nil {
+			return nil, err
+		}
+		if upper.Data == nil || lower.Data == nil {
+			return nil, fmt.Errorf("missing data block")
+		}
+		appendNewItems(st, sec, upper)
+		if upper.Data.Total == lower.Data.Total || overlaps(upper.Data.List, lower.Data.List) {
+			return lower, nil
+		}
+	}
+	fmt.Printf("[%s] Page boundary still moving after %d attempts, continuing\n", sec.Name, maxRefetch)
+	return lower, nil
+}
+
+// overlaps reports whether the older page reaches into the range of the newer
+// one, which means no comment can have fallen between them.
+func overlaps(newer, older []Item) bool {
+	if len(newer) == 0 || len(older) == 0 {
+		return false
+	}
+	oldestOfNewer := oldestID(newer)
+	for _, item := range older {
+		if item.ID >= oldestOfNewer {
+			return true
+		}
+	}
+	return false
+}
+
+// sortNewestFirst returns the items ordered by descending ID.
+func sortNewestFirst(items []Item) []Item {
+	sorted := append([]Item(nil), items...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID > sorted[j].ID })
+	return sorted
+}